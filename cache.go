@@ -0,0 +1,110 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/cockroachdb/crlfmt/internal/cache"
+)
+
+// formatCache records the files that are known to be formatted. It is nil if
+// caching is disabled.
+var formatCache *cache.Cache
+
+// openCache opens the cache if mode, the value of the -cache setting, is on.
+func openCache(mode string) error {
+	if mode != "on" {
+		return nil
+	}
+	dir, err := cache.DefaultDir()
+	if err != nil {
+		// Without a cache directory, run without a cache.
+		return nil
+	}
+	formatCache, err = cache.Open(dir)
+	return err
+}
+
+// cacheCommand runs the cache subcommand with the given arguments.
+func cacheCommand(args []string) error {
+	if len(args) != 1 || args[0] != "clean" {
+		return fmt.Errorf("usage: crlfmt cache clean")
+	}
+	dir, err := cache.DefaultDir()
+	if err != nil {
+		return err
+	}
+	return cache.Clean(dir)
+}
+
+// cacheKey returns the cache key for formatting src, the contents of the file
+// at path, with opts. The key covers the absolute path of the file as well as
+// its contents, because goimports resolves imports relative to the file's
+// directory.
+func cacheKey(path string, src []byte, opts format.Options) (cache.Key, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return cache.Key{}, err
+	}
+	optsJSON, err := json.Marshal(opts)
+	if err != nil {
+		return cache.Key{}, err
+	}
+	return cache.NewKey([]byte(version()), optsJSON, []byte(abs), src), nil
+}
+
+var versionOnce struct {
+	sync.Once
+	v string
+}
+
+// version returns a string that identifies this build of crlfmt. Released
+// builds are identified by their module version. Development builds are
+// identified by a hash of the executable, so that cache entries made by one
+// development build are not trusted by the next.
+func version() string {
+	versionOnce.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			versionOnce.v = info.Main.Version
+			return
+		}
+		versionOnce.v = "unknown"
+		exe, err := os.Executable()
+		if err != nil {
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return
+		}
+		versionOnce.v = "devel " + hex.EncodeToString(h.Sum(nil))
+	})
+	return versionOnce.v
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/cockroachdb/crlfmt/internal/config"
+)
+
+// configs holds the settings derived from each configuration file.
+var configs struct {
+	finder   config.Finder
+	mu       sync.Mutex
+	settings map[configKey]*settings
+}
+
+// A configKey identifies the settings derived from a configuration file and
+// the subset of its overrides that apply to a file.
+type configKey struct {
+	f         *config.File
+	overrides string
+}
+
+// settingsFor returns the settings for the file at path. Flags given on the
+// command line take precedence over the configuration file that applies to
+// the file, if any, and the overrides in the configuration file take
+// precedence over its other settings.
+func settingsFor(path string) (*settings, error) {
+	f, err := configs.finder.Find(filepath.Dir(path))
+	if err != nil || f == nil {
+		return flags, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(filepath.Dir(f.Path), abs)
+	if err != nil {
+		return nil, err
+	}
+	var overrides []int
+	for i := range f.Overrides {
+		if f.Overrides[i].Matches(filepath.ToSlash(rel)) {
+			overrides = append(overrides, i)
+		}
+	}
+	return configSettings(f, overrides)
+}
+
+// dirSettings returns the settings for dir itself, which are those of the
+// configuration file that applies to dir, without any overrides.
+func dirSettings(dir string) (*settings, error) {
+	f, err := configs.finder.Find(dir)
+	if err != nil || f == nil {
+		return flags, err
+	}
+	return configSettings(f, nil)
+}
+
+// configSettings returns the settings derived from f and the overrides in f
+// with the given indexes.
+func configSettings(f *config.File, overrides []int) (*settings, error) {
+	key := configKey{f: f, overrides: fmt.Sprint(overrides)}
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	if s, ok := configs.settings[key]; ok {
+		return s, nil
+	}
+	s, err := configure(f, overrides)
+	if err != nil {
+		return nil, err
+	}
+	if configs.settings == nil {
+		configs.settings = make(map[configKey]*settings)
+	}
+	configs.settings[key] = s
+	return s, nil
+}
+
+// runSettings are the settings that apply to a whole run rather than to
+// individual files, so they cannot be overridden.
+var runSettings = map[string]bool{
+	"j": true, "cache": true, "check": true, "l": true, "since": true,
+	"lines": true, "git-diff-lines": true, "staged": true,
+	"stdin-filename": true, "files-from": true,
+	"tags": true, "all-variants": true, "prune": true, "stop-at-modules": true,
+	"v": true, "shard": true, "color": true, "diff-context": true,
+	"diff-words": true, "format": true, "patch": true,
+}
+
+// configure returns the settings specified by the configuration file f, the
+// overrides in f with the given indexes, and the command line. Each setting in
+// f has the name of a flag, and is parsed like that flag. A relative srcdir is
+// relative to the directory of f. In an override, ignore is a boolean that
+// skips the matching files.
+func configure(f *config.File, overrides []int) (*settings, error) {
+	fs := flag.NewFlagSet(f.Path, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	s := newSettings(fs)
+
+	set := func(e config.Setting) error {
+		if fs.Lookup(e.Key) == nil {
+			return f.Errorf(e, "unknown setting %q", e.Key)
+		}
+		value := e.Value
+		if e.Key == "srcdir" && value != "" && !filepath.IsAbs(value) {
+			value = filepath.Join(filepath.Dir(f.Path), value)
+		}
+		if err := fs.Set(e.Key, value); err != nil {
+			return f.Errorf(e, "invalid value %q for %s: %s", e.Value, e.Key, err)
+		}
+		return nil
+	}
+	for _, e := range f.Settings {
+		if err := set(e); err != nil {
+			return nil, err
+		}
+	}
+	// Check all of the overrides, not only the ones that apply, so that
+	// mistakes are reported no matter which files are formatted.
+	for _, o := range f.Overrides {
+		for _, e := range o.Settings {
+			if runSettings[e.Key] {
+				return nil, f.Errorf(e, "%s cannot be overridden", e.Key)
+			} else if e.Key == "ignore" {
+				if _, err := strconv.ParseBool(e.Value); err != nil {
+					return nil, f.Errorf(e, "invalid value %q for ignore: must be true or false", e.Value)
+				}
+			} else if fs.Lookup(e.Key) == nil {
+				return nil, f.Errorf(e, "unknown setting %q", e.Key)
+			}
+		}
+	}
+	for _, i := range overrides {
+		for _, e := range f.Overrides[i].Settings {
+			if e.Key == "ignore" {
+				s.ignored, _ = strconv.ParseBool(e.Value)
+			} else if err := set(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var err error
+	flag.Visit(func(fl *flag.Flag) {
+		if err == nil {
+			err = fs.Set(fl.Name, fl.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(); err != nil {
+		return nil, fmt.Errorf("%s: %s", f.Path, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/cockroachdb/crlfmt/internal/diff"
+	"github.com/cockroachdb/crlfmt/internal/parser"
+)
+
+// An Edit replaces the Length bytes of a file's source starting at byte Offset
+// with NewText.
+type Edit struct {
+	Offset  int
+	Length  int
+	NewText string
+}
+
+// End returns the offset just past the bytes replaced by the edit.
+func (e Edit) End() int {
+	return e.Offset + e.Length
+}
+
+// Edits formats src like Source, but returns the changes to src as a list of
+// edits instead of the formatted file. The edits are sorted by offset, do not
+// overlap, and only cover the bytes that formatting changes. An empty list
+// means that src is already formatted.
+func Edits(filename string, src []byte, opts Options) ([]Edit, error) {
+	out, err := Source(filename, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeEdits(src, out), nil
+}
+
+// maxRefine is the largest changed region, in bytes, that ComputeEdits refines
+// by diffing the characters in the region.
+const maxRefine = 4 << 10
+
+// ComputeEdits returns a minimal list of edits that transforms src into out.
+// The edits are computed by diffing the lines of both texts. Small changed
+// regions are then refined by diffing their characters, so that, for example,
+// rewrapping a line produces edits that only insert line breaks.
+func ComputeEdits(src, out []byte) []Edit {
+	if bytes.Equal(src, out) {
+		return nil
+	}
+	a, b := diff.Lines(src), diff.Lines(out)
+	aOff, bOff := tokenOffsets(a), tokenOffsets(b)
+
+	var edits []Edit
+	for _, c := range diff.Diff(a, b) {
+		from := src[aOff[c.A]:aOff[c.A+c.Del]]
+		to := out[bOff[c.B]:bOff[c.B+c.Ins]]
+
+		// Trim the common prefix and suffix, taking care not to split a
+		// multi-byte character.
+		p := 0
+		for p < len(from) && p < len(to) && from[p] == to[p] {
+			p++
+		}
+		for p > 0 && p < len(from) && !utf8.RuneStart(from[p]) {
+			p--
+		}
+		s := 0
+		for s < len(from)-p && s < len(to)-p && from[len(from)-1-s] == to[len(to)-1-s] {
+			s++
+		}
+		for s > 0 && !utf8.RuneStart(from[len(from)-s]) {
+			s--
+		}
+		offset := aOff[c.A] + p
+		from, to = from[p:len(from)-s], to[p:len(to)-s]
+
+		if len(from) == 0 || len(to) == 0 || len(from)+len(to) > maxRefine {
+			edits = append(edits, Edit{Offset: offset, Length: len(from), NewText: string(to)})
+			continue
+		}
+		ra, rb := diff.Runes(from), diff.Runes(to)
+		raOff, rbOff := tokenOffsets(ra), tokenOffsets(rb)
+		for _, rc := range diff.Diff(ra, rb) {
+			edits = append(edits, Edit{
+				Offset:  offset + raOff[rc.A],
+				Length:  raOff[rc.A+rc.Del] - raOff[rc.A],
+				NewText: string(to[rbOff[rc.B]:rbOff[rc.B+rc.Ins]]),
+			})
+		}
+	}
+	return edits
+}
+
+// tokenOffsets returns the offset of the start of each token, followed by the
+// offset of the end of the last token.
+func tokenOffsets(tokens [][]byte) []int {
+	offsets := make([]int, len(tokens)+1)
+	for i, t := range tokens {
+		offsets[i+1] = offsets[i] + len(t)
+	}
+	return offsets
+}
+
+// ApplyEdits returns the result of applying edits to src. The edits may be in
+// any order, but must not overlap.
+func ApplyEdits(src []byte, edits []Edit) ([]byte, error) {
+	edits = append([]Edit(nil), edits...)
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Offset < edits[j].Offset })
+
+	var buf bytes.Buffer
+	buf.Grow(len(src))
+	last := 0
+	for _, e := range edits {
+		if e.Offset < last || e.Length < 0 || e.End() > len(src) {
+			return nil, fmt.Errorf("invalid edit at offset %d: overlapping or out of range", e.Offset)
+		}
+		buf.Write(src[last:e.Offset])
+		buf.WriteString(e.NewText)
+		last = e.End()
+	}
+	buf.Write(src[last:])
+	return buf.Bytes(), nil
+}
+
+// replace returns an edit that replaces the bytes in [start, end) with text,
+// or nothing if those bytes are already equal to text.
+func replace(f *parser.File, start, end token.Pos, text []byte) []Edit {
+	if bytes.Equal(f.Slice(start, end), text) {
+		return nil
+	}
+	return []Edit{{Offset: f.Offset(start), Length: int(end - start), NewText: string(text)}}
+}
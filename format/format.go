@@ -0,0 +1,213 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package format formats Go source code according to the CockroachDB Style
+// Guide. It is the library behind the crlfmt command.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/printer"
+	"go/token"
+
+	"github.com/cockroachdb/crlfmt/internal/parser"
+)
+
+// Options controls how Source formats a file.
+type Options struct {
+	// Wrap is the column at which function signatures are wrapped.
+	Wrap int
+	// WrapDoc is the column at which doc strings for functions, variables,
+	// constants, and types are wrapped. Multiline comments denoted by /* are
+	// left alone.
+	WrapDoc int
+	// TabWidth is the tab width used for column calculations.
+	TabWidth int
+	// Fast skips running goimports and simplify.
+	Fast bool
+	// GroupImports groups imports by type.
+	GroupImports bool
+	// Passes, if non-empty, lists the names of the only passes to run.
+	Passes []string
+	// Skip lists the names of passes not to run.
+	Skip []string
+	// LocalPrefix is a comma-separated list of import path prefixes. Imports
+	// beginning with one of these prefixes are put after 3rd-party packages.
+	LocalPrefix string
+	// SrcDir, if set, resolves imports as if the source file is from the
+	// given directory. If a file is given, its parent directory is used.
+	SrcDir string
+	// Lines, if non-empty, restricts formatting to the top-level
+	// declarations that overlap these ranges of lines. The rest of the file
+	// is left as is.
+	Lines []LineRange
+}
+
+// DefaultOptions returns the options used by the crlfmt command when no flags
+// are specified.
+func DefaultOptions() Options {
+	return Options{
+		Wrap:         100,
+		WrapDoc:      160,
+		TabWidth:     2,
+		GroupImports: true,
+	}
+}
+
+// Source formats src, the contents of the Go source file named filename, and
+// returns the result. The filename is used to resolve imports and to report
+// errors; the file itself is not read.
+//
+// The syntax tree of the file is shared by the passes in the pipeline. It is
+// parsed once after goimports runs, and is only printed and parsed again if
+// simplification modifies it. The edits computed by the remaining passes are
+// applied to the source in one step at the end.
+func Source(filename string, src []byte, opts Options) ([]byte, error) {
+	return source(filename, src, opts, nil)
+}
+
+// source implements Source. If record is non-nil, it is called with the
+// source of the file after each step of the pipeline that changes it, along
+// with the names of the passes that made the changes.
+func source(
+	filename string, src []byte, opts Options, record func(passes []string, src []byte),
+) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	passes, err := opts.passes()
+	if err != nil {
+		return nil, err
+	}
+	s := state{filename: filename, src: src}
+	for len(passes) > 0 {
+		switch p := passes[0].(type) {
+		case sourcePass:
+			src, err := p.source(filename, s.src, opts)
+			if err != nil {
+				return nil, err
+			}
+			if record != nil && !bytes.Equal(src, s.src) {
+				record([]string{p.Name()}, src)
+			}
+			s.setSrc(src)
+			passes = passes[1:]
+
+		case syntaxPass:
+			if err := s.parse(); err != nil {
+				return nil, err
+			}
+			var changed []string
+			for len(passes) > 0 {
+				p, ok := passes[0].(syntaxPass)
+				if !ok {
+					break
+				}
+				if p.syntax(s.file) {
+					changed = append(changed, p.Name())
+				}
+				passes = passes[1:]
+			}
+			if len(changed) > 0 {
+				prCfg := &printer.Config{
+					Tabwidth: opts.TabWidth,
+					Mode:     printer.UseSpaces | printer.TabIndent,
+				}
+				var buf bytes.Buffer
+				if err := prCfg.Fprint(&buf, s.fset, s.file); err != nil {
+					return nil, err
+				}
+				if record != nil {
+					record(changed, buf.Bytes())
+				}
+				s.setSrc(buf.Bytes())
+			}
+
+		case editPass:
+			if err := s.parse(); err != nil {
+				return nil, err
+			}
+			file := parser.NewFile(s.fset, s.file, s.src)
+			var edits []Edit
+			for len(passes) > 0 {
+				p, ok := passes[0].(editPass)
+				if !ok {
+					break
+				}
+				e, err := p.edits(file, opts)
+				if err != nil {
+					return nil, err
+				}
+				edits = append(edits, e...)
+				if record != nil && len(e) > 0 {
+					// The edits of each pass are applied on top of those of
+					// the passes before it, as they are at the end.
+					src, err := ApplyEdits(s.src, edits)
+					if err != nil {
+						return nil, err
+					}
+					record([]string{p.Name()}, src)
+				}
+				passes = passes[1:]
+			}
+			if len(edits) > 0 {
+				src, err := ApplyEdits(s.src, edits)
+				if err != nil {
+					return nil, err
+				}
+				s.setSrc(src)
+			}
+
+		default:
+			return nil, fmt.Errorf("pass %s has unknown kind %T", p.Name(), p)
+		}
+	}
+	if len(opts.Lines) > 0 {
+		return restrict(filename, src, s.src, opts.Lines)
+	}
+	return s.src, nil
+}
+
+// state is the state of a file as it moves through the pipeline.
+type state struct {
+	filename string
+	src      []byte
+	// fset and file hold the syntax tree of src, if it has been parsed. The
+	// syntax tree is discarded whenever src changes.
+	fset *token.FileSet
+	file *ast.File
+}
+
+// setSrc replaces the source of the file.
+func (s *state) setSrc(src []byte) {
+	s.src = src
+	s.fset, s.file = nil, nil
+}
+
+// parse parses the source of the file, unless it has been parsed already.
+func (s *state) parse() error {
+	if s.file != nil {
+		return nil
+	}
+	fset := token.NewFileSet()
+	file, err := goparser.ParseFile(fset, s.filename, s.src, goparser.AllErrors|goparser.ParseComments)
+	if err != nil {
+		return err
+	}
+	s.fset, s.file = fset, file
+	return nil
+}
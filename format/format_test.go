@@ -0,0 +1,412 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var rewrite = flag.Bool("rewrite", false, "used to rewrite output")
+
+func TestSource(t *testing.T) {
+	opts := DefaultOptions()
+	opts.TabWidth = 8
+	opts.GroupImports = false
+	opts.WrapDoc = 80
+	files, err := filepath.Glob("testdata/*.in.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			inBytes, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outFile := strings.Replace(file, ".in.go", ".out.go", -1)
+
+			output, err := Source(file, inBytes, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *rewrite {
+				err := os.WriteFile(outFile, output, 0666)
+				require.NoError(t, err)
+			} else {
+				expBytes, err := os.ReadFile(outFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				require.Equal(t, string(expBytes), string(output))
+			}
+		})
+	}
+}
+
+func TestPasses(t *testing.T) {
+	const src = `package test
+
+// Foo is a function with a very long doc comment that needs to be wrapped.
+func Foo(aaaaaaaaaa int, bbbbbbbbbb int, cccccccccc int) (dddddddddd int, eeeeeeeeee int) {
+	return 0, 0
+}
+`
+	opts := DefaultOptions()
+	opts.Wrap = 40
+	opts.WrapDoc = 40
+
+	for _, tc := range []struct {
+		passes, skip []string
+		expDoc       bool
+		expSig       bool
+	}{
+		{expDoc: true, expSig: true},
+		{skip: []string{"wrap"}, expDoc: true},
+		{skip: []string{"wrapdoc"}, expSig: true},
+		{passes: []string{"wrap"}, expSig: true},
+		{passes: []string{"wrap", "wrapdoc"}, skip: []string{"wrap"}, expDoc: true},
+	} {
+		opts := opts
+		opts.Passes, opts.Skip = tc.passes, tc.skip
+		out, err := Source("test.go", []byte(src), opts)
+		require.NoError(t, err)
+		require.Equal(t, !tc.expDoc, strings.Contains(string(out), "very long doc comment"), "%s", out)
+		require.Equal(t, !tc.expSig, strings.Contains(string(out), "(aaaaaaaaaa int"), "%s", out)
+	}
+
+	opts.Skip = []string{"nonexistent"}
+	_, err := Source("test.go", []byte(src), opts)
+	require.EqualError(t, err, `unknown pass "nonexistent"`)
+}
+
+func TestEdits(t *testing.T) {
+	opts := DefaultOptions()
+	files, err := filepath.Glob("testdata/*.in.go")
+	require.NoError(t, err)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			require.NoError(t, err)
+			exp, err := Source(file, src, opts)
+			require.NoError(t, err)
+			edits, err := Edits(file, src, opts)
+			require.NoError(t, err)
+			out, err := ApplyEdits(src, edits)
+			require.NoError(t, err)
+			require.Equal(t, string(exp), string(out))
+			for i, e := range edits {
+				require.NotEqual(t, src[e.Offset:e.End()], e.NewText, "edit %d is a no-op", i)
+			}
+		})
+	}
+}
+
+func TestSourceLines(t *testing.T) {
+	src := []byte(`package p
+
+import (
+	"fmt"
+	"os"
+)
+
+// A is a function whose doc comment and signature are both long enough to be wrapped.
+func A(aaaaaaaaaa int, bbbbbbbbbb int, cccccccccc int) (dddddddddd int, eeeeeeeeee int) {
+	fmt.Println()
+	return 0, 0
+}
+
+var   x = 1
+
+// B is a function whose doc comment and signature are both long enough to be wrapped.
+func B(aaaaaaaaaa int, bbbbbbbbbb int, cccccccccc int) (dddddddddd int, eeeeeeeeee int) {
+	return   0, 0
+}
+`)
+	opts := DefaultOptions()
+	opts.Wrap = 60
+	opts.WrapDoc = 60
+	full, err := Source("p.go", src, opts)
+	require.NoError(t, err)
+
+	// Line 19 is in the body of B, so only B is formatted. The unused import
+	// and the spacing of x are left alone.
+	opts.Lines = []LineRange{{Start: 19, End: 19}}
+	out, err := Source("p.go", src, opts)
+	require.NoError(t, err)
+	require.Equal(t, string(src[:bytes.Index(src, []byte("// B"))]), string(out[:bytes.Index(out, []byte("// B"))]))
+	require.Equal(t, string(full[bytes.Index(full, []byte("// B")):]), string(out[bytes.Index(out, []byte("// B")):]))
+
+	// Line 8 is A's doc comment, and lines 3-4 overlap the imports.
+	opts.Lines = []LineRange{{Start: 3, End: 4}, {Start: 8, End: 8}}
+	out, err = Source("p.go", src, opts)
+	require.NoError(t, err)
+	require.Equal(t, string(full[:bytes.Index(full, []byte("var"))]), string(out[:bytes.Index(out, []byte("var"))]))
+	require.Equal(t, string(src[bytes.Index(src, []byte("var")):]), string(out[bytes.Index(out, []byte("var")):]))
+
+	// Lines between declarations select nothing.
+	opts.Lines = []LineRange{{Start: 13, End: 13}}
+	out, err = Source("p.go", src, opts)
+	require.NoError(t, err)
+	require.Equal(t, string(src), string(out))
+
+	opts.Lines = []LineRange{{Start: 3, End: 2}}
+	_, err = Source("p.go", src, opts)
+	require.EqualError(t, err, "invalid line range 3:2")
+}
+
+func TestSourceHunks(t *testing.T) {
+	src := []byte(`package p
+
+import (
+	"github.com/cockroachdb/crlfmt/format"
+	"fmt"
+)
+
+var   x = format.Hunk{}
+
+// A is a function whose doc comment and signature are both long enough to be wrapped.
+func A(aaaaaaaaaa int, bbbbbbbbbb int, cccccccccc int) (dddddddddd int, eeeeeeeeee int) {
+	fmt.Println()
+	return 0, 0
+}
+`)
+	opts := DefaultOptions()
+	opts.Wrap = 60
+	opts.WrapDoc = 60
+	exp, err := Source("p.go", src, opts)
+	require.NoError(t, err)
+	out, hunks, err := SourceHunks("p.go", src, opts)
+	require.NoError(t, err)
+	require.Equal(t, string(exp), string(out))
+	require.Equal(t, []Hunk{
+		{OldStart: 4, OldLines: 0, NewStart: 4, NewLines: 2, Passes: []string{"goimports"}},
+		{OldStart: 5, OldLines: 1, NewStart: 7, NewLines: 0, Passes: []string{"goimports"}},
+		{OldStart: 8, OldLines: 1, NewStart: 9, NewLines: 1, Passes: []string{"goimports"}},
+		{OldStart: 10, OldLines: 2, NewStart: 11, NewLines: 5, Passes: []string{"wrap", "wrapdoc"}},
+	}, hunks)
+
+	// Without goimports, the imports pass groups the imports, and the spacing
+	// of x is left alone.
+	opts.Skip = []string{"goimports"}
+	_, hunks, err = SourceHunks("p.go", src, opts)
+	require.NoError(t, err)
+	require.Equal(t, []Hunk{
+		{OldStart: 4, OldLines: 0, NewStart: 4, NewLines: 2, Passes: []string{"imports"}},
+		{OldStart: 5, OldLines: 1, NewStart: 7, NewLines: 0, Passes: []string{"imports"}},
+		{OldStart: 10, OldLines: 2, NewStart: 11, NewLines: 5, Passes: []string{"wrap", "wrapdoc"}},
+	}, hunks)
+
+	opts.Skip = nil
+	_, hunks, err = SourceHunks("p.go", exp, opts)
+	require.NoError(t, err)
+	require.Empty(t, hunks)
+}
+
+func TestIsGenerated(t *testing.T) {
+	for _, tc := range []struct {
+		src       string
+		generated bool
+	}{
+		{"// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage p\n", true},
+		{"// Copyright 2018.\n\n// Code generated by x. DO NOT EDIT.\n\n// Package p does things.\npackage p\n", true},
+		{"// Code generated by x. DO NOT EDIT.\r\npackage p\r\n", true},
+		{"package p\n\n// Code generated by x. DO NOT EDIT.\n", false},
+		{"// Code generated by x. DO NOT EDIT\npackage p\n", false},
+		{"/* Code generated by x. DO NOT EDIT. */\npackage p\n", false},
+		{"//Code generated by x. DO NOT EDIT.\npackage p\n", false},
+		{"// Code generated by x. DO NOT EDIT.\n", false},
+	} {
+		require.Equal(t, tc.generated, IsGenerated([]byte(tc.src)), "%q", tc.src)
+	}
+}
+
+func TestComputeEdits(t *testing.T) {
+	for _, tc := range []struct {
+		src, out string
+		exp      []Edit
+	}{
+		{"a\nb\n", "a\nb\n", nil},
+		{"func f(a, b int) {\n", "func f(\n\ta, b int,\n) {\n", []Edit{
+			{Offset: 7, Length: 0, NewText: "\n\t"},
+			{Offset: 15, Length: 0, NewText: ",\n"},
+		}},
+		{"x\n// héllo\ny\n", "x\n// hèllo\ny\n", []Edit{{Offset: 6, Length: 2, NewText: "è"}}},
+		{"a\nb", "a\nb\n", []Edit{{Offset: 3, Length: 0, NewText: "\n"}}},
+	} {
+		edits := ComputeEdits([]byte(tc.src), []byte(tc.out))
+		out, err := ApplyEdits([]byte(tc.src), edits)
+		require.NoError(t, err)
+		require.Equal(t, tc.out, string(out))
+		if tc.exp != nil || len(edits) == 0 {
+			require.Equal(t, tc.exp, edits, "%q -> %q", tc.src, tc.out)
+		}
+	}
+
+	_, err := ApplyEdits([]byte("abc"), []Edit{{Offset: 0, Length: 2}, {Offset: 1, Length: 1}})
+	require.Error(t, err)
+}
+
+func TestPositionMap(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Wrap = 40
+	opts.WrapDoc = 40
+	opts.Passes = []string{"wrap", "wrapdoc"}
+	for _, file := range []string{"testdata/funcsigs.in.go", "testdata/comments.in.go"} {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			require.NoError(t, err)
+			out, m, err := SourceMap(file, src, opts)
+			require.NoError(t, err)
+			require.NotEqual(t, string(src), string(out))
+
+			// Rewrapping signatures and doc strings does not remove any tokens
+			// other than comments, so every other token in the source must map
+			// to the same token in the output.
+			fset := token.NewFileSet()
+			var s scanner.Scanner
+			s.Init(fset.AddFile(file, -1, len(src)), src, nil, scanner.ScanComments)
+			for {
+				pos, tok, lit := s.Scan()
+				if tok == token.EOF {
+					break
+				}
+				if lit == "" || tok == token.SEMICOLON || tok == token.COMMENT {
+					continue
+				}
+				p := fset.Position(pos)
+				off := m.Offset(p.Offset)
+				require.True(t, strings.HasPrefix(string(out[off:]), lit),
+					"%s: %q mapped to %q", p, lit, out[off:off+len(lit)])
+
+				line, col := m.Position(p.Line, p.Column)
+				outLines := strings.Split(string(out), "\n")
+				firstLine := strings.Split(lit, "\n")[0]
+				require.True(t, strings.HasPrefix(outLines[line-1][col-1:], firstLine), "%s: %q", p, lit)
+			}
+		})
+	}
+
+	// Words in a reflowed doc string map to the same words.
+	src := []byte("package test\n\n// Foo has a doc string that is reflowed.\nfunc Foo() {}\n")
+	out, m, err := SourceMap("test.go", src, opts)
+	require.NoError(t, err)
+	require.Equal(t, "package test\n\n// Foo has a doc string that is\n// reflowed.\nfunc Foo() {}\n", string(out))
+	for _, word := range []string{"Foo has", "string", "that", "reflowed.", "func"} {
+		off := m.Offset(strings.Index(string(src), word))
+		require.True(t, strings.HasPrefix(string(out[off:]), word), "%q mapped to %q", word, out[off:])
+	}
+	line, col := m.Position(3, 33) // the "r" in "reflowed."
+	require.Equal(t, []int{4, 4}, []int{line, col})
+}
+
+func TestSourceConcurrent(t *testing.T) {
+	const src = `package test
+
+import (
+	"fmt"
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/stretchr/testify/require"
+)
+
+var _ = fmt.Sprint
+var _ = format.Source
+var _ = require.NoError
+`
+	// The local prefix affects how goimports groups imports, so the output
+	// of each call reveals which prefix goimports used.
+	prefixes := []string{"", "github.com/cockroachdb", "github.com/stretchr"}
+	exp := make(map[string]string)
+	for _, prefix := range prefixes {
+		opts := DefaultOptions()
+		opts.GroupImports = false
+		opts.LocalPrefix = prefix
+		out, err := Source("test.go", []byte(src), opts)
+		require.NoError(t, err)
+		exp[prefix] = string(out)
+	}
+	require.NotEqual(t, exp[prefixes[1]], exp[prefixes[2]])
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < cap(errs); i++ {
+		prefix := prefixes[i%len(prefixes)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := DefaultOptions()
+			opts.GroupImports = false
+			opts.LocalPrefix = prefix
+			out, err := Source("test.go", []byte(src), opts)
+			if err == nil && string(out) != exp[prefix] {
+				err = fmt.Errorf("prefix %q: unexpected output:\n%s", prefix, out)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+// largeFile returns the source of a Go file with n functions, each with a doc
+// comment and signature long enough to be wrapped.
+func largeFile(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("package test\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, `
+// Func%[1]d is function number %[1]d, and it has a doc comment that is long enough to be wrapped at the default doc wrapping column.
+func Func%[1]d(firstArgument string, secondArgument int, thirdArgument []string) (result string, err error) {
+	parts := []string{firstArgument, fmt.Sprint(secondArgument)}
+	for _, s := range thirdArgument {
+		parts = append(parts, strings.ToUpper(s))
+	}
+	return strings.Join(parts, ","), nil
+}
+`, i)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkSource(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		src := largeFile(n)
+		for _, fast := range []bool{false, true} {
+			opts := DefaultOptions()
+			opts.Fast = fast
+			b.Run(fmt.Sprintf("funcs=%d/fast=%t", n, fast), func(b *testing.B) {
+				b.SetBytes(int64(len(src)))
+				for i := 0; i < b.N; i++ {
+					if _, err := Source("test.go", src, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
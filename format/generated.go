@@ -0,0 +1,51 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	goparser "go/parser"
+	"go/token"
+	"regexp"
+	"strings"
+)
+
+// generatedRE matches the comment that marks a generated file.
+var generatedRE = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// IsGenerated reports whether src, the contents of a Go source file, is
+// marked as generated by a line comment of the form
+//
+//	// Code generated ... DO NOT EDIT.
+//
+// before the package clause, following the convention described at
+// https://go.dev/s/generatedcode. A file whose package clause cannot be
+// parsed is not reported as generated.
+func IsGenerated(src []byte) bool {
+	f, err := goparser.ParseFile(token.NewFileSet(), "", src, goparser.PackageClauseOnly|goparser.ParseComments)
+	if err != nil {
+		return false
+	}
+	for _, g := range f.Comments {
+		if g.Pos() > f.Package {
+			break
+		}
+		for _, c := range g.List {
+			if generatedRE.MatchString(strings.TrimSuffix(c.Text, "\r")) {
+				return true
+			}
+		}
+	}
+	return false
+}
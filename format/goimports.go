@@ -0,0 +1,104 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cockroachdb/gostdlib/x/tools/imports"
+)
+
+// goimportsPass runs goimports, which also runs gofmt.
+type goimportsPass struct{}
+
+func (goimportsPass) Name() string { return "goimports" }
+func (goimportsPass) Doc() string  { return "add missing imports, remove unused ones, and gofmt" }
+
+func (goimportsPass) source(filename string, src []byte, opts Options) ([]byte, error) {
+	importOpts := imports.Options{
+		AllErrors:  true,
+		Comments:   true,
+		TabIndent:  false,
+		TabWidth:   opts.TabWidth,
+		FormatOnly: false,
+	}
+
+	pathForImports := filename
+	if opts.SrcDir != "" {
+		base := filepath.Base(filename)
+		if isDirectory(opts.SrcDir) {
+			pathForImports = filepath.Join(opts.SrcDir, base)
+		} else {
+			pathForImports = filepath.Join(filepath.Dir(opts.SrcDir), base)
+		}
+	}
+
+	localPrefix.acquire(opts.LocalPrefix)
+	defer localPrefix.release()
+	return imports.Process(pathForImports, src, &importOpts)
+}
+
+// localPrefix guards imports.LocalPrefix, which goimports reads from a global
+// variable rather than from its options. Calls to goimports that use the same
+// local prefix may run concurrently; a call that needs a different prefix
+// waits until the calls using the current one have finished.
+var localPrefix = newPrefixGate()
+
+type prefixGate struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	users int // the number of calls using imports.LocalPrefix
+}
+
+func newPrefixGate() *prefixGate {
+	g := &prefixGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire sets imports.LocalPrefix to prefix, and prevents it from changing
+// until release is called.
+func (g *prefixGate) acquire(prefix string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.users > 0 && imports.LocalPrefix != prefix {
+		g.cond.Wait()
+	}
+	if imports.LocalPrefix != prefix {
+		imports.LocalPrefix = prefix
+	}
+	g.users++
+}
+
+func (g *prefixGate) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.users--
+	if g.users == 0 {
+		g.cond.Broadcast()
+	}
+}
+
+// isDirectory returns true if the path is a directory. False is
+// returned on any error.
+func isDirectory(path string) bool {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fileInfo.IsDir()
+}
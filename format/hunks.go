@@ -0,0 +1,102 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import "github.com/cockroachdb/crlfmt/internal/diff"
+
+// A Hunk is a run of consecutive lines of a file that formatting replaces.
+type Hunk struct {
+	// OldStart is the first line of the source that the hunk replaces, and
+	// OldLines is the number of lines it replaces. If OldLines is zero, the
+	// hunk inserts lines before line OldStart. Lines are numbered from one.
+	OldStart int `json:"old_start"`
+	OldLines int `json:"old_lines"`
+	// NewStart is the first line of the output that replaces them, and
+	// NewLines is the number of lines that replace them.
+	NewStart int `json:"new_start"`
+	NewLines int `json:"new_lines"`
+	// Passes names the passes that made changes within the hunk, in pipeline
+	// order.
+	Passes []string `json:"passes"`
+}
+
+// OldRange returns the first and last lines of a source with n lines that h
+// replaces. A hunk that inserts lines is located at the line before which it
+// inserts them, or at the last line if it inserts them at the end.
+func (h Hunk) OldRange(n int) (start, end int) {
+	if h.OldLines > 0 {
+		return h.OldStart, h.OldStart + h.OldLines - 1
+	}
+	line := h.OldStart
+	if line > n {
+		line = n
+	}
+	if line < 1 {
+		line = 1
+	}
+	return line, line
+}
+
+// SourceHunks formats src like Source, and also returns the lines that
+// formatting changed, along with the passes that changed them.
+func SourceHunks(filename string, src []byte, opts Options) ([]byte, []Hunk, error) {
+	type step struct {
+		passes []string
+		src    []byte
+	}
+	var steps []step
+	out, err := source(filename, src, opts, func(passes []string, src []byte) {
+		steps = append(steps, step{passes: passes, src: src})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a, b := diff.Lines(src), diff.Lines(out)
+	aOff := tokenOffsets(a)
+	changes := diff.Diff(a, b)
+	hunks := make([]Hunk, len(changes))
+	for i, c := range changes {
+		hunks[i] = Hunk{OldStart: c.A + 1, OldLines: c.Del, NewStart: c.B + 1, NewLines: c.Ins}
+	}
+
+	// A pass made changes within a hunk if the edits it made to the output of
+	// the step before it touch the text of the hunk, as found in that output.
+	prev := src
+	for _, st := range steps {
+		m := NewPositionMap(src, prev)
+		edits := ComputeEdits(prev, st.src)
+		for i, c := range changes {
+			start, end := m.Offset(aOff[c.A]), m.Offset(aOff[c.A+c.Del])
+			for _, e := range edits {
+				if touches(e, start, end) {
+					hunks[i].Passes = append(hunks[i].Passes, st.passes...)
+					break
+				}
+			}
+		}
+		prev = st.src
+	}
+	return out, hunks, nil
+}
+
+// touches reports whether the edit e changes text in [start, end), or inserts
+// text at start if the range is empty.
+func touches(e Edit, start, end int) bool {
+	if start == end {
+		return e.Offset <= start && start <= e.End()
+	}
+	return e.Offset < end && (e.End() > start || e.Offset >= start)
+}
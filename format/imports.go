@@ -0,0 +1,163 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/cockroachdb/crlfmt/internal/parser"
+	"github.com/cockroachdb/crlfmt/internal/render"
+	"github.com/cockroachdb/gostdlib/go/format"
+)
+
+// importsPass groups imports by type.
+type importsPass struct{}
+
+func (importsPass) Name() string { return "imports" }
+func (importsPass) Doc() string  { return "group imports by type" }
+
+func (importsPass) edits(file *parser.File, opts Options) ([]Edit, error) {
+	src := file.Src()
+	importMapping := remapImports(file, opts.LocalPrefix)
+
+	var edits []Edit
+	for _, imp := range file.ImportDecls() {
+		blocks := importMapping[imp]
+		if blocks == nil {
+			// This import declaration is meant to be removed. If it's
+			// surrounded by blank lines, remove those too.
+			//
+			// If the import block is surrounded by blank lines, remove the
+			// blank lines too.
+			startPos, endPos := imp.Pos, imp.End
+			if off := file.Offset(startPos); off-2 >= 0 && src[off-1] == '\n' && src[off-2] == '\n' {
+				startPos = file.Pos(off - 1)
+			}
+			if off := file.Offset(endPos); off+1 < len(src) && src[off] == '\n' && src[off+1] == '\n' {
+				endPos = file.Pos(off + 1)
+			}
+			edits = append(edits, replace(file, startPos, endPos, nil)...)
+			continue
+		}
+
+		var importBuf bytes.Buffer
+		if imp.Doc != nil && blocks[0].Size() > 1 {
+			importBuf.Write(file.Slice(imp.Doc.Pos(), imp.Doc.End()))
+			importBuf.WriteByte('\n')
+		}
+		for i, block := range blocks {
+			if i > 0 {
+				importBuf.WriteString("\n\n")
+			}
+			render.Imports(&importBuf, file, block)
+		}
+		newBytes, err := format.Source(importBuf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("grouping imports for %s: %s", file.Name(), err)
+		}
+		edits = append(edits, replace(file, imp.Pos, imp.End, newBytes)...)
+	}
+	return edits, nil
+}
+
+// remapImports maps each existing import declaration in the file to an import
+// block that should replace it. An import block can contain multiple import
+// declarations, to indicate that the existing single import declaration should
+// be replaced with multiple separate import declarations, or nil, to indicate
+// that the import declaration should be removed entirely.
+//
+// The goal is to have just one import declaration, within which imports are
+// grouped standard library imports and non-standard library imports. An
+// exception is made for cgo, whose "C" psuedo-imports are extracted into
+// separate import declarations.
+//
+// Imports beginning with one of the comma-separated prefixes in localPrefix
+// are grouped after non-standard library imports.
+func remapImports(
+	file *parser.File, localPrefix string,
+) map[*parser.ImportDecl][]render.ImportBlock {
+	imports := file.ImportSpecs()
+	stdlibImports := make([]parser.ImportSpec, 0, len(imports))
+	otherImports := make([]parser.ImportSpec, 0, len(imports))
+	localImports := make([]parser.ImportSpec, 0, len(imports))
+
+	localPrefixes := []string{}
+	if localPrefix != "" {
+		lps := strings.Split(localPrefix, ",")
+		localPrefixes = make([]string, 0, len(lps))
+		for _, lp := range lps {
+			if !strings.HasSuffix(lp, "/") {
+				lp += "/"
+			}
+			localPrefixes = append(localPrefixes, lp)
+		}
+	}
+
+NEXT_IMPORT:
+	for _, imp := range imports {
+		impPath := imp.Path()
+		if impPath == "C" {
+			continue NEXT_IMPORT
+		}
+
+		for _, lp := range localPrefixes {
+			if strings.HasPrefix(impPath, lp) {
+				localImports = append(localImports, imp)
+				continue NEXT_IMPORT
+			}
+		}
+
+		if strings.Contains(impPath, ".") {
+			otherImports = append(otherImports, imp)
+			continue NEXT_IMPORT
+		}
+
+		stdlibImports = append(stdlibImports, imp)
+	}
+
+	mainBlock := render.ImportBlock{stdlibImports, otherImports, localImports}
+	needMainBlock := mainBlock.Size() > 0
+
+	mapping := map[*parser.ImportDecl][]render.ImportBlock{}
+	impDecls := file.ImportDecls()
+	for _, imp := range impDecls {
+		var blocks []render.ImportBlock
+		var cImports []parser.ImportSpec
+		for _, spec := range imp.Specs {
+			if spec.Path() == "C" {
+				cImports = append(cImports, spec)
+			}
+		}
+		if needMainBlock && len(cImports) != len(imp.Specs) {
+			// The first import declaration we see that contains something other
+			// than "C" psuedo-imports will be our main import block.
+			blocks = append(blocks, mainBlock)
+			needMainBlock = false
+		}
+		// If there were any "C" psuedo-imports in this declaration, split them
+		// out into their own import declarations.
+		for _, imp := range cImports {
+			if imp.Doc == nil {
+				// A cgo import without a doc comment has no effect. Remove it.
+				continue
+			}
+			blocks = append(blocks, render.ImportBlock{{imp}})
+		}
+		mapping[imp] = blocks
+	}
+	return mapping
+}
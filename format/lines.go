@@ -0,0 +1,105 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"fmt"
+	"go/ast"
+	goparser "go/parser"
+	"go/token"
+)
+
+// A LineRange is a range of lines, numbered from 1, that includes both Start
+// and End.
+type LineRange struct {
+	Start, End int
+}
+
+func (r LineRange) String() string {
+	return fmt.Sprintf("%d:%d", r.Start, r.End)
+}
+
+// overlaps reports whether any of the ranges overlaps the lines from start to
+// end.
+func overlaps(ranges []LineRange, start, end int) bool {
+	for _, r := range ranges {
+		if r.Start <= end && r.End >= start {
+			return true
+		}
+	}
+	return false
+}
+
+// restrict returns src with only those of the changes that formatting it into
+// out made within the top-level declarations, including their doc comments,
+// that overlap the given lines. A declaration's region extends over all of its
+// lines, so that comments that follow it on its last line are included.
+func restrict(filename string, src, out []byte, lines []LineRange) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := goparser.ParseFile(fset, filename, src, goparser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	tok := fset.File(f.Pos())
+
+	// regions holds the [start, end) offsets of the declarations that may be
+	// changed.
+	var regions [][2]int
+	for _, d := range f.Decls {
+		start := d.Pos()
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		case *ast.GenDecl:
+			if d.Doc != nil {
+				start = d.Doc.Pos()
+			}
+		}
+		startLine, endLine := tok.Line(start), tok.Line(d.End())
+		if !overlaps(lines, startLine, endLine) {
+			continue
+		}
+		end := len(src)
+		if endLine < tok.LineCount() {
+			end = tok.Offset(tok.LineStart(endLine + 1))
+		}
+		regions = append(regions, [2]int{tok.Offset(tok.LineStart(startLine)), end})
+	}
+
+	var edits []Edit
+	for _, e := range ComputeEdits(src, out) {
+		for _, r := range regions {
+			// An insertion at the end of a region is at the start of the
+			// line that follows the declaration, so it is outside.
+			if e.Offset >= r[0] && e.End() <= r[1] && (e.Length > 0 || e.Offset < r[1]) {
+				edits = append(edits, e)
+				break
+			}
+		}
+	}
+	res, err := ApplyEdits(src, edits)
+	if err != nil {
+		return nil, err
+	}
+	// Changes that span the boundary of a declaration are dropped, which
+	// could in principle leave a declaration partially formatted. Make sure
+	// that did not produce invalid code.
+	if _, err := goparser.ParseFile(token.NewFileSet(), filename, res, goparser.ParseComments); err != nil {
+		return nil, fmt.Errorf("formatting lines %v of %s: %s", lines, filename, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"fmt"
+	"go/ast"
+
+	"github.com/cockroachdb/crlfmt/internal/parser"
+)
+
+// A Pass is a single named stage of the formatting pipeline. Each pass sees
+// the output of the passes that precede it in the pipeline.
+type Pass interface {
+	// Name returns the name that selects the pass in Options.Passes and
+	// Options.Skip.
+	Name() string
+	// Doc returns a short description of the pass.
+	Doc() string
+}
+
+// A sourcePass rewrites the complete source of a file.
+type sourcePass interface {
+	Pass
+	source(filename string, src []byte, opts Options) ([]byte, error)
+}
+
+// A syntaxPass rewrites the syntax tree of a file in place. It reports
+// whether it modified the syntax tree.
+type syntaxPass interface {
+	Pass
+	syntax(f *ast.File) bool
+}
+
+// An editPass computes edits to the source of a file. The edits made by
+// consecutive edit passes are computed against the same parse of the file and
+// must not overlap.
+type editPass interface {
+	Pass
+	edits(f *parser.File, opts Options) ([]Edit, error)
+}
+
+// registry lists every pass in pipeline order. A new pass is added to the
+// pipeline by implementing one of the pass kinds above and adding it here.
+var registry = []Pass{
+	goimportsPass{},
+	simplifyPass{},
+	importsPass{},
+	wrapPass{},
+	wrapDocPass{},
+}
+
+// Passes returns every pass in pipeline order.
+func Passes() []Pass {
+	return append([]Pass(nil), registry...)
+}
+
+// passes returns the passes enabled by opts, in pipeline order.
+func (opts Options) passes() ([]Pass, error) {
+	known := make(map[string]bool, len(registry))
+	for _, p := range registry {
+		known[p.Name()] = true
+	}
+	only := make(map[string]bool, len(opts.Passes))
+	for _, name := range opts.Passes {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown pass %q", name)
+		}
+		only[name] = true
+	}
+	skip := make(map[string]bool, len(opts.Skip))
+	for _, name := range opts.Skip {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown pass %q", name)
+		}
+		skip[name] = true
+	}
+	if opts.Fast {
+		skip[goimportsPass{}.Name()] = true
+		skip[simplifyPass{}.Name()] = true
+	}
+	if !opts.GroupImports {
+		skip[importsPass{}.Name()] = true
+	}
+
+	var out []Pass
+	for _, p := range registry {
+		if len(only) > 0 && !only[p.Name()] {
+			continue
+		}
+		if skip[p.Name()] {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// Validate returns an error if opts refers to a pass that does not exist, or
+// if one of its Lines is not a valid range: ranges start at line one and end
+// at or after their start.
+func (opts Options) Validate() error {
+	for _, r := range opts.Lines {
+		if r.Start < 1 || r.End < r.Start {
+			return fmt.Errorf("invalid line range %s", r)
+		}
+	}
+	_, err := opts.passes()
+	return err
+}
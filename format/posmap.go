@@ -0,0 +1,129 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A PositionMap translates locations in the source of a file to the
+// corresponding locations in its formatted output. Text that formatting
+// leaves alone maps to the same text in the output. Within text that
+// formatting rewrites, such as a rewrapped signature or a reflowed doc
+// comment, a location maps to the same non-space character in the output, if
+// formatting kept it.
+type PositionMap struct {
+	src   []byte
+	edits []Edit
+	// starts holds the offset in the output at which the text of each edit
+	// begins.
+	starts   []int
+	srcLines []int
+	outLines []int
+}
+
+// SourceMap formats src like Source, and also returns a PositionMap from src
+// to the formatted output.
+func SourceMap(filename string, src []byte, opts Options) ([]byte, *PositionMap, error) {
+	out, err := Source(filename, src, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, NewPositionMap(src, out), nil
+}
+
+// NewPositionMap returns a PositionMap from src to out.
+func NewPositionMap(src, out []byte) *PositionMap {
+	m := &PositionMap{
+		src:      src,
+		edits:    ComputeEdits(src, out),
+		srcLines: lineStarts(src),
+		outLines: lineStarts(out),
+	}
+	delta := 0
+	for _, e := range m.edits {
+		m.starts = append(m.starts, e.Offset+delta)
+		delta += len(e.NewText) - e.Length
+	}
+	return m
+}
+
+// Offset translates the byte offset off in the source to a byte offset in the
+// output. An offset in text that formatting removed maps to the start of the
+// text that replaced it.
+func (m *PositionMap) Offset(off int) int {
+	// Find the last edit that starts at or before off.
+	i := sort.Search(len(m.edits), func(i int) bool { return m.edits[i].Offset > off }) - 1
+	if i < 0 {
+		return off
+	}
+	e := m.edits[i]
+	if off >= e.End() {
+		return m.starts[i] + len(e.NewText) + off - e.End()
+	}
+	// off is within the bytes replaced by e. Count the non-space characters
+	// of the replaced text that precede off, and find the same number of
+	// non-space characters in the new text.
+	//
+	// Note that an edit can only contain off if it replaces at least one
+	// byte, so that offsets at which text is inserted map to after the
+	// inserted text.
+	n := 0
+	for _, r := range string(m.src[e.Offset:off]) {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	text := e.NewText
+	j := 0
+	for j < len(text) {
+		r, size := utf8.DecodeRuneInString(text[j:])
+		if !unicode.IsSpace(r) {
+			if n == 0 {
+				break
+			}
+			n--
+		}
+		j += size
+	}
+	return m.starts[i] + j
+}
+
+// Position translates the 1-based line and column (in bytes) of a location in
+// the source to the line and column of the location in the output.
+func (m *PositionMap) Position(line, col int) (int, int) {
+	if line < 1 || line > len(m.srcLines) {
+		return line, col
+	}
+	off := m.Offset(m.srcLines[line-1] + col - 1)
+	i := sort.Search(len(m.outLines), func(i int) bool { return m.outLines[i] > off }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return i + 1, off - m.outLines[i] + 1
+}
+
+// lineStarts returns the offset of the start of each line in text.
+func lineStarts(text []byte) []int {
+	starts := []int{0}
+	for i, c := range text {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package format
+
+import (
+	"bytes"
+	"go/ast"
+
+	"github.com/cockroachdb/crlfmt/internal/parser"
+	"github.com/cockroachdb/crlfmt/internal/render"
+)
+
+// wrapPass wraps function signatures at Options.Wrap.
+type wrapPass struct{}
+
+func (wrapPass) Name() string { return "wrap" }
+func (wrapPass) Doc() string  { return "wrap function signatures" }
+
+func (wrapPass) edits(f *parser.File, opts Options) ([]Edit, error) {
+	var edits []Edit
+	for _, d := range f.Decls {
+		fn, ok := d.(*parser.FuncDecl)
+		if !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		render.Func(&buf, f, fn, opts.TabWidth, opts.Wrap)
+		edits = append(edits, replace(f, fn.Type.Params.Pos()+1, fn.Type.End(), buf.Bytes())...)
+	}
+	return edits, nil
+}
+
+// wrapDocPass wraps doc strings at Options.WrapDoc.
+type wrapDocPass struct{}
+
+func (wrapDocPass) Name() string { return "wrapdoc" }
+func (wrapDocPass) Doc() string {
+	return "wrap doc strings for functions, variables, constants, and types"
+}
+
+func (wrapDocPass) edits(f *parser.File, opts Options) ([]Edit, error) {
+	var edits []Edit
+	for _, d := range f.Decls {
+		var doc *ast.CommentGroup
+		switch d := d.(type) {
+		case *parser.FuncDecl:
+			doc = d.Doc
+		case *parser.ConstDecl:
+			doc = d.Doc
+		case *parser.VarDecl:
+			doc = d.Doc
+		case *parser.TypeDecl:
+			doc = d.Doc
+		}
+		if doc == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		render.DocString(&buf, f, doc, opts.WrapDoc)
+		edits = append(edits, replace(f, doc.Pos(), doc.End(), buf.Bytes())...)
+	}
+	return edits, nil
+}
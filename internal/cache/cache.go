@@ -0,0 +1,154 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package cache implements an on-disk record of the files that are known to
+// be formatted, so that they can be skipped when they have not changed.
+//
+// Each entry is an empty file named by a key, which is a hash of everything
+// that determines the result of formatting a file. Entries are created
+// atomically, so a cache can be shared by concurrent processes.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// trimInterval is how often unused entries are removed.
+	trimInterval = 24 * time.Hour
+	// trimLimit is how long an entry can go unused before it is removed.
+	trimLimit = 30 * 24 * time.Hour
+	// mtimeInterval is how often the modification time of an entry is
+	// updated when it is used.
+	mtimeInterval = time.Hour
+)
+
+// A Key identifies a cache entry.
+type Key [sha256.Size]byte
+
+// NewKey returns the key for the given parts. Each part is hashed along with
+// its length, so that different splits of the same bytes have different keys.
+func NewKey(parts ...[]byte) Key {
+	h := sha256.New()
+	for _, p := range parts {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(p)))
+		h.Write(n[:])
+		h.Write(p)
+	}
+	var k Key
+	h.Sum(k[:0])
+	return k
+}
+
+// A Cache is a directory of entries.
+type Cache struct {
+	dir string
+}
+
+// DefaultDir returns the default location of the cache, which is the crlfmt
+// directory within the user's cache directory ($XDG_CACHE_HOME or ~/.cache
+// on Linux).
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "crlfmt"), nil
+}
+
+// Open opens the cache in dir, creating the directory if needed. It also
+// removes entries that have not been used in a long time, at most once a day.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	c := &Cache{dir: dir}
+	c.trim()
+	return c, nil
+}
+
+// path returns the file that holds the entry for k.
+func (c *Cache) path(k Key) string {
+	s := hex.EncodeToString(k[:])
+	return filepath.Join(c.dir, s[:2], s)
+}
+
+// Has reports whether the cache contains an entry for k.
+func (c *Cache) Has(k Key) bool {
+	p := c.path(k)
+	fi, err := os.Stat(p)
+	if err != nil {
+		return false
+	}
+	// Record that the entry is in use, so that it is not trimmed.
+	if now := time.Now(); now.Sub(fi.ModTime()) > mtimeInterval {
+		_ = os.Chtimes(p, now, now)
+	}
+	return true
+}
+
+// Put adds an entry for k to the cache.
+func (c *Cache) Put(k Key) error {
+	p := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(p), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Clean removes the cache and all of its entries.
+func Clean(dir string) error {
+	return os.RemoveAll(dir)
+}
+
+// trim removes entries that have not been used within trimLimit, unless the
+// cache was trimmed within trimInterval. Errors are ignored; at worst, unused
+// entries stay around until the next trim.
+func (c *Cache) trim() {
+	now := time.Now()
+	marker := filepath.Join(c.dir, "trim.txt")
+	if fi, err := os.Stat(marker); err == nil && now.Sub(fi.ModTime()) < trimInterval {
+		return
+	} else if errors.Is(err, os.ErrNotExist) {
+		// A new cache has nothing to trim.
+		_ = os.WriteFile(marker, nil, 0666)
+		return
+	}
+	_ = os.WriteFile(marker, nil, 0666)
+
+	subdirs, _ := os.ReadDir(c.dir)
+	for _, sub := range subdirs {
+		if !sub.IsDir() {
+			continue
+		}
+		entries, _ := os.ReadDir(filepath.Join(c.dir, sub.Name()))
+		for _, e := range entries {
+			fi, err := e.Info()
+			if err == nil && now.Sub(fi.ModTime()) > trimLimit {
+				_ = os.Remove(filepath.Join(c.dir, sub.Name(), e.Name()))
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "crlfmt")
+	c, err := Open(dir)
+	require.NoError(t, err)
+
+	k1 := NewKey([]byte("ab"), []byte("c"))
+	k2 := NewKey([]byte("a"), []byte("bc"))
+	require.NotEqual(t, k1, k2)
+	require.False(t, c.Has(k1))
+
+	// Concurrent puts of the same key must not fail.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Put(k1); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	require.True(t, c.Has(k1))
+	require.False(t, c.Has(k2))
+
+	// Entries that have not been used in a long time are trimmed when the
+	// cache is next opened, as long as it has not been trimmed recently.
+	old := time.Now().Add(-2 * trimLimit)
+	require.NoError(t, os.Chtimes(c.path(k1), old, old))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "trim.txt"), old, old))
+	c, err = Open(dir)
+	require.NoError(t, err)
+	require.False(t, c.Has(k1))
+
+	require.NoError(t, c.Put(k2))
+	require.NoError(t, Clean(dir))
+	_, err = os.Stat(dir)
+	require.True(t, os.IsNotExist(err))
+}
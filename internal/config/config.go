@@ -0,0 +1,256 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package config reads crlfmt configuration files.
+//
+// A configuration file is a YAML mapping from setting names to values. A
+// value is either a scalar or a list of scalars, which is equivalent to the
+// comma-separated list of its elements:
+//
+//	wrap: 120
+//	local: github.com/cockroachdb/cockroach
+//	skip: [wrapdoc]
+//
+// The overrides setting holds a list of mappings of settings that only apply
+// to the files matching their files patterns:
+//
+//	overrides:
+//	  - files: pkg/sql/sem/tree
+//	    wrap: 140
+//	  - files: ["**/testdata/**", "*.pb.go"]
+//	    ignore: true
+//
+// The configuration for a file is found in the nearest directory, starting
+// with the file's own directory, that contains a configuration file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Name is the name of a configuration file.
+const Name = ".crlfmt.yaml"
+
+// A Setting is one entry of a configuration file.
+type Setting struct {
+	Key   string
+	Value string
+	// Line is the line of the file on which the setting appears.
+	Line int
+}
+
+// A File is a parsed configuration file.
+type File struct {
+	// Path is the absolute path of the file.
+	Path      string
+	Settings  []Setting
+	Overrides []Override
+}
+
+// An Override holds settings that apply only to the files matched by one of
+// its patterns. Patterns are matched by Match against paths relative to the
+// directory of the configuration file.
+type Override struct {
+	Files    []string
+	Settings []Setting
+	// Line is the line of the file on which the override begins.
+	Line int
+}
+
+// Matches reports whether the override applies to the file at rel, a
+// slash-separated path relative to the directory of the configuration file.
+func (o *Override) Matches(rel string) bool {
+	for _, pattern := range o.Files {
+		if Match(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Errorf returns an error about the setting s in f, prefixed with the file
+// name and line of the setting.
+func (f *File) Errorf(s Setting, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", f.Path, s.Line, fmt.Sprintf(format, args...))
+}
+
+// Parse parses data, the contents of the configuration file at path.
+func Parse(path string, data []byte) (*File, error) {
+	f := &File{Path: path}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	if len(doc.Content) == 0 {
+		// The file is empty.
+		return f, nil
+	}
+	var err error
+	f.Settings, err = parseSettings(path, doc.Content[0], func(k, v *yaml.Node) (bool, error) {
+		if k.Value != "overrides" {
+			return false, nil
+		}
+		if v.Kind != yaml.SequenceNode {
+			return false, fmt.Errorf("%s:%d: overrides: expected a list", path, v.Line)
+		}
+		for _, n := range v.Content {
+			o, err := parseOverride(path, n)
+			if err != nil {
+				return false, err
+			}
+			f.Overrides = append(f.Overrides, o)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseOverride parses an override, which is a mapping of settings with an
+// additional files key that holds its patterns.
+func parseOverride(path string, n *yaml.Node) (Override, error) {
+	o := Override{Line: n.Line}
+	var err error
+	o.Settings, err = parseSettings(path, n, func(k, v *yaml.Node) (bool, error) {
+		if k.Value != "files" {
+			return false, nil
+		}
+		files, err := list(v)
+		if err != nil {
+			return false, fmt.Errorf("%s:%d: files: %s", path, v.Line, err)
+		}
+		for _, pattern := range files {
+			if err := checkPattern(pattern); err != nil {
+				return false, fmt.Errorf("%s:%d: files: %s", path, v.Line, err)
+			}
+		}
+		o.Files = files
+		return true, nil
+	})
+	if err != nil {
+		return Override{}, err
+	}
+	if len(o.Files) == 0 {
+		return Override{}, fmt.Errorf("%s:%d: override has no files", path, n.Line)
+	}
+	return o, nil
+}
+
+// parseSettings parses the mapping of settings m. Entries for which special
+// returns true are handled by special, and are not returned as settings.
+func parseSettings(
+	path string, m *yaml.Node, special func(k, v *yaml.Node) (bool, error),
+) ([]Setting, error) {
+	if m.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s:%d: expected a mapping of settings", path, m.Line)
+	}
+	var settings []Setting
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		k, v := m.Content[i], m.Content[i+1]
+		if k.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%s:%d: expected a setting name", path, k.Line)
+		}
+		if seen[k.Value] {
+			return nil, fmt.Errorf("%s:%d: duplicate setting %q", path, k.Line, k.Value)
+		}
+		seen[k.Value] = true
+		if ok, err := special(k, v); err != nil {
+			return nil, err
+		} else if ok {
+			continue
+		}
+		values, err := list(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %s", path, v.Line, k.Value, err)
+		}
+		settings = append(settings, Setting{Key: k.Value, Value: strings.Join(values, ","), Line: k.Line})
+	}
+	return settings, nil
+}
+
+// list returns the value of a scalar node, or the values of a list of scalars.
+func list(n *yaml.Node) ([]string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return []string{n.Value}, nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, e := range n.Content {
+			if e.Kind != yaml.ScalarNode {
+				return nil, errors.New("expected a list of scalars")
+			}
+			values = append(values, e.Value)
+		}
+		return values, nil
+	default:
+		return nil, errors.New("expected a scalar or a list of scalars")
+	}
+}
+
+// A Finder finds the configuration files that apply to directories. Each
+// directory is only searched once. It is safe for concurrent use.
+type Finder struct {
+	mu   sync.Mutex
+	dirs map[string]*File
+}
+
+// Find returns the configuration file in dir or the nearest of its parents,
+// or nil if there is none.
+func (fi *Finder) Find(dir string) (*File, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if fi.dirs == nil {
+		fi.dirs = make(map[string]*File)
+	}
+	return fi.find(dir)
+}
+
+func (fi *Finder) find(dir string) (*File, error) {
+	if f, ok := fi.dirs[dir]; ok {
+		return f, nil
+	}
+	path := filepath.Join(dir, Name)
+	data, err := os.ReadFile(path)
+	var f *File
+	switch {
+	case err == nil:
+		if f, err = Parse(path, data); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	default:
+		if parent := filepath.Dir(dir); parent != dir {
+			if f, err = fi.find(parent); err != nil {
+				return nil, err
+			}
+		}
+	}
+	fi.dirs[dir] = f
+	return f, nil
+}
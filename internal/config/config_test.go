@@ -0,0 +1,151 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	f, err := Parse("c.yaml", []byte(`
+# The column to wrap at.
+wrap: 120
+fast: true
+skip: [wrapdoc, imports]
+local: github.com/cockroachdb
+`))
+	require.NoError(t, err)
+	require.Equal(t, []Setting{
+		{Key: "wrap", Value: "120", Line: 3},
+		{Key: "fast", Value: "true", Line: 4},
+		{Key: "skip", Value: "wrapdoc,imports", Line: 5},
+		{Key: "local", Value: "github.com/cockroachdb", Line: 6},
+	}, f.Settings)
+	require.EqualError(t, f.Errorf(f.Settings[1], "bad %s", "value"), "c.yaml:4: bad value")
+
+	f, err = Parse("c.yaml", nil)
+	require.NoError(t, err)
+	require.Empty(t, f.Settings)
+
+	for _, tc := range []struct {
+		data string
+		err  string
+	}{
+		{"- wrap", "c.yaml:1: expected a mapping of settings"},
+		{"wrap: 1\nwrap: 2", `c.yaml:2: duplicate setting "wrap"`},
+		{"wrap:\n  a: 1", "c.yaml:2: wrap: expected a scalar or a list of scalars"},
+		{"skip: [[a]]", "c.yaml:1: skip: expected a list of scalars"},
+		{"wrap: [", "c.yaml: line 1: did not find expected node content"},
+	} {
+		_, err := Parse("c.yaml", []byte(tc.data))
+		require.EqualError(t, err, tc.err, tc.data)
+	}
+}
+
+func TestFinder(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "a", "b")
+	require.NoError(t, os.MkdirAll(sub, 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", Name), []byte("wrap: 80\n"), 0666))
+
+	var fi Finder
+	f, err := fi.Find(sub)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "a", Name), f.Path)
+	f2, err := fi.Find(filepath.Join(root, "a"))
+	require.NoError(t, err)
+	require.Same(t, f, f2)
+
+	// The temporary directory may be below a directory with a configuration
+	// file, so only check that the file in a is not found from root.
+	f, err = fi.Find(root)
+	require.NoError(t, err)
+	if f != nil {
+		require.NotEqual(t, filepath.Join(root, "a", Name), f.Path)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	f, err := Parse("c.yaml", []byte(`wrap: 100
+overrides:
+  - files: pkg/sql/sem/tree
+    wrap: 140
+  - files: ["**/testdata/**", "*.pb.go"]
+    ignore: true
+`))
+	require.NoError(t, err)
+	require.Equal(t, []Setting{{Key: "wrap", Value: "100", Line: 1}}, f.Settings)
+	require.Equal(t, []Override{
+		{
+			Files:    []string{"pkg/sql/sem/tree"},
+			Settings: []Setting{{Key: "wrap", Value: "140", Line: 4}},
+			Line:     3,
+		},
+		{
+			Files:    []string{"**/testdata/**", "*.pb.go"},
+			Settings: []Setting{{Key: "ignore", Value: "true", Line: 6}},
+			Line:     5,
+		},
+	}, f.Overrides)
+	require.True(t, f.Overrides[0].Matches("pkg/sql/sem/tree/expr.go"))
+	require.False(t, f.Overrides[0].Matches("pkg/sql/sem/treewalk/expr.go"))
+	require.True(t, f.Overrides[1].Matches("pkg/x/testdata/a.go"))
+	require.True(t, f.Overrides[1].Matches("pkg/x/x.pb.go"))
+	require.False(t, f.Overrides[1].Matches("pkg/x/x.go"))
+
+	for _, tc := range []struct {
+		data string
+		err  string
+	}{
+		{"overrides: {}", "c.yaml:1: overrides: expected a list"},
+		{"overrides:\n  - wrap: 1", "c.yaml:2: override has no files"},
+		{"overrides:\n  - files: '[a'", `c.yaml:2: files: invalid pattern "[a"`},
+		{"overrides:\n  - files: a\n    files: b", `c.yaml:3: duplicate setting "files"`},
+	} {
+		_, err := Parse("c.yaml", []byte(tc.data))
+		require.EqualError(t, err, tc.err, tc.data)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"a.go", "a.go", true},
+		{"a.go", "x/y/a.go", true},
+		{"*.pb.go", "x/y.pb.go", true},
+		{"*.pb.go", "x/y.go", false},
+		{"x", "x/y/z.go", true},
+		{"x/y", "x/y/z.go", true},
+		{"x/y", "a/x/y/z.go", false},
+		{"/x/y", "x/y/z.go", true},
+		{"x/y/", "x/y/z.go", true},
+		{"x/*.go", "x/z.go", true},
+		{"x/*.go", "x/y/z.go", false},
+		{"x/**/*.go", "x/z.go", true},
+		{"x/**/*.go", "x/y/w/z.go", true},
+		{"**/testdata/**", "testdata/a.go", true},
+		{"**/testdata/**", "x/testdata/y/a.go", true},
+		{"**/testdata/**", "x/testdatum/a.go", false},
+		{"testdata", "x/testdata/a.go", true},
+	} {
+		require.Equal(t, tc.match, Match(tc.pattern, tc.name), "%s %s", tc.pattern, tc.name)
+	}
+}
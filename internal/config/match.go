@@ -0,0 +1,78 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Match reports whether the slash-separated path name matches pattern.
+//
+// Each element of the pattern is matched against an element of name as by
+// path.Match, except that a ** element matches any number of elements. A
+// pattern that does not contain a slash matches names whose last element
+// matches it, in any directory. A pattern that matches a directory matches
+// every name within that directory.
+func Match(pattern, name string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	p := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	n := strings.Split(name, "/")
+	// Try each prefix of name, so that a pattern that matches a directory
+	// matches the names within it.
+	for i := len(n); i > 0; i-- {
+		if match(p, n[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether the pattern elements p match all of the name elements
+// n.
+func match(p, n []string) bool {
+	for len(p) > 0 {
+		if p[0] == "**" {
+			for i := 0; i <= len(n); i++ {
+				if match(p[1:], n[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(n) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(p[0], n[0]); !ok {
+			return false
+		}
+		p, n = p[1:], n[1:]
+	}
+	return len(n) == 0
+}
+
+// checkPattern returns an error if pattern is malformed.
+func checkPattern(pattern string) error {
+	for _, e := range strings.Split(pattern, "/") {
+		if _, err := path.Match(e, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
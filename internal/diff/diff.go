@@ -0,0 +1,193 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package diff computes differences between two texts using the linear space
+// variant of Myers' algorithm, and formats them as unified diffs. Texts are
+// compared as sequences of tokens, usually lines.
+package diff
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// A Change replaces Del tokens of the old text, starting at token A, with Ins
+// tokens of the new text, starting at token B. Tokens are numbered from zero.
+type Change struct {
+	A, B     int
+	Del, Ins int
+}
+
+// Lines splits text into lines. Each line keeps its trailing newline, except
+// possibly the last.
+func Lines(text []byte) [][]byte {
+	var lines [][]byte
+	for len(text) > 0 {
+		i := bytes.IndexByte(text, '\n') + 1
+		if i == 0 {
+			i = len(text)
+		}
+		lines = append(lines, text[:i])
+		text = text[i:]
+	}
+	return lines
+}
+
+// Runes splits text into its UTF-8 encoded characters.
+func Runes(text []byte) [][]byte {
+	runes := make([][]byte, 0, len(text))
+	for len(text) > 0 {
+		_, n := utf8.DecodeRune(text)
+		runes = append(runes, text[:n])
+		text = text[n:]
+	}
+	return runes
+}
+
+// Diff returns the changes that transform the tokens a into the tokens b. The
+// changes are in order and separated by at least one unchanged token.
+func Diff(a, b [][]byte) []Change {
+	// Replace each token by a small integer, so that tokens can be compared
+	// cheaply.
+	ids := make(map[string]int)
+	intern := func(lines [][]byte) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[string(l)]
+			if !ok {
+				id = len(ids)
+				ids[string(l)] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := differ{a: intern(a), b: intern(b)}
+	d.delA = make([]bool, len(a))
+	d.insB = make([]bool, len(b))
+	n := len(a) + len(b)
+	d.vf = make([]int, 2*n+2)
+	d.vb = make([]int, 2*n+2)
+	d.compare(0, len(a), 0, len(b))
+
+	var changes []Change
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && !d.delA[i] && !d.insB[j] {
+			i++
+			j++
+			continue
+		}
+		c := Change{A: i, B: j}
+		for i < len(a) && d.delA[i] {
+			i++
+		}
+		for j < len(b) && d.insB[j] {
+			j++
+		}
+		c.Del, c.Ins = i-c.A, j-c.B
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// differ holds the state of a diff computation. Tokens of a that are deleted
+// are marked in delA, and tokens of b that are inserted are marked in insB.
+type differ struct {
+	a, b       []int
+	delA, insB []bool
+	// vf and vb hold the furthest reaching x coordinate on each diagonal of
+	// the forward and backward searches for the middle snake.
+	vf, vb []int
+}
+
+// compare marks the differences between a[aLo:aHi] and b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.insB[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.delA[i] = true
+		}
+	default:
+		x0, y0, x1, y1 := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x0, bLo, y0)
+		d.compare(x1, aHi, y1, bHi)
+	}
+}
+
+// middleSnake finds the middle snake of an optimal path from (aLo, bLo) to
+// (aHi, bHi) and returns its start and end points. Both sequences must be
+// non-empty and must differ in their first and last elements.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x0, y0, x1, y1 int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta&1 != 0
+	off := (n+m+1)/2 + 1
+	vf, vb := d.vf, d.vb
+	vf[off+1], vb[off+1] = 0, 0
+	for k := 0; k <= (n+m+1)/2; k++ {
+		// Search forward from (aLo, bLo).
+		for diag := -k; diag <= k; diag += 2 {
+			var x int
+			if diag == -k || (diag != k && vf[off+diag-1] < vf[off+diag+1]) {
+				x = vf[off+diag+1]
+			} else {
+				x = vf[off+diag-1] + 1
+			}
+			y := x - diag
+			sx, sy := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			vf[off+diag] = x
+			if rdiag := delta - diag; odd && rdiag >= -(k-1) && rdiag <= k-1 && x+vb[off+rdiag] >= n {
+				return aLo + sx, bLo + sy, aLo + x, bLo + y
+			}
+		}
+		// Search backward from (aHi, bHi), in coordinates that count from the
+		// end of each sequence.
+		for diag := -k; diag <= k; diag += 2 {
+			var x int
+			if diag == -k || (diag != k && vb[off+diag-1] < vb[off+diag+1]) {
+				x = vb[off+diag+1]
+			} else {
+				x = vb[off+diag-1] + 1
+			}
+			y := x - diag
+			sx, sy := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			vb[off+diag] = x
+			if fdiag := delta - diag; !odd && fdiag >= -k && fdiag <= k && x+vf[off+fdiag] >= n {
+				return aHi - x, bHi - y, aHi - sx, bHi - sy
+			}
+		}
+	}
+	panic("diff: no middle snake found")
+}
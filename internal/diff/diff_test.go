@@ -0,0 +1,238 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLines(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		exp  []string
+	}{
+		{"", nil},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b"}},
+		{"a\n\nb\n", []string{"a\n", "\n", "b\n"}},
+	} {
+		var lines []string
+		for _, l := range Lines([]byte(tc.text)) {
+			lines = append(lines, string(l))
+		}
+		require.Equal(t, tc.exp, lines, "%q", tc.text)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randLines := func() [][]byte {
+		var lines [][]byte
+		for i, n := 0, rng.Intn(30); i < n; i++ {
+			lines = append(lines, []byte{byte('a' + rng.Intn(4)), '\n'})
+		}
+		return lines
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randLines(), randLines()
+		changes := Diff(a, b)
+
+		// Applying the changes to a must produce b.
+		var out [][]byte
+		last := 0
+		edited := 0
+		for j, c := range changes {
+			require.True(t, c.Del > 0 || c.Ins > 0)
+			if j > 0 {
+				prev := changes[j-1]
+				require.Greater(t, c.A, prev.A+prev.Del, "changes must not be adjacent")
+			}
+			out = append(out, a[last:c.A]...)
+			out = append(out, b[c.B:c.B+c.Ins]...)
+			last = c.A + c.Del
+			edited += c.Del + c.Ins
+		}
+		out = append(out, a[last:]...)
+		require.Equal(t, join(b), join(out))
+
+		// The changes must be minimal.
+		require.Equal(t, len(a)+len(b)-2*lcs(a, b), edited, "%q -> %q", join(a), join(b))
+	}
+}
+
+func join(lines [][]byte) string {
+	var sb strings.Builder
+	for _, l := range lines {
+		sb.Write(l)
+	}
+	return sb.String()
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b [][]byte) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if string(a[i]) == string(b[j]) {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] > dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestWords(t *testing.T) {
+	var words []string
+	for _, w := range Words([]byte("if x_1 := f(a,\tb); x_1 != nil {\n")) {
+		words = append(words, string(w))
+	}
+	require.Equal(t, []string{
+		"if", " ", "x_1", " ", ":", "=", " ", "f", "(", "a", ",", "\t", "b", ")", ";", " ",
+		"x_1", " ", "!", "=", " ", "nil", " ", "{", "\n",
+	}, words)
+}
+
+func TestUnified(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a, b string
+		opts UnifiedOptions
+		exp  string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			opts: UnifiedOptions{Context: 3},
+		},
+		{
+			name: "context",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			opts: UnifiedOptions{Context: 1},
+			exp: `--- old
++++ new
+@@ -4,3 +4,3 @@
+ 4
+-5
++five
+ 6
+`,
+		},
+		{
+			name: "hunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "one\n2\n3\n4\n5\n6\n7\n8\nnine\n",
+			opts: UnifiedOptions{Context: 2},
+			exp: `--- old
++++ new
+@@ -1,3 +1,3 @@
+-1
++one
+ 2
+ 3
+@@ -7,3 +7,3 @@
+ 7
+ 8
+-9
++nine
+`,
+		},
+		{
+			name: "merged hunks",
+			a:    "1\n2\n3\n4\n5\n",
+			b:    "one\n2\n3\n4\nfive\n",
+			opts: UnifiedOptions{Context: 2},
+			exp: `--- old
++++ new
+@@ -1,5 +1,5 @@
+-1
++one
+ 2
+ 3
+ 4
+-5
++five
+`,
+		},
+		{
+			name: "insertion",
+			a:    "a\nb\n",
+			b:    "a\nx\nb\n",
+			opts: UnifiedOptions{},
+			exp: `--- old
++++ new
+@@ -1,0 +2 @@
++x
+`,
+		},
+		{
+			name: "no newline",
+			a:    "a\nb",
+			b:    "a\nb\n",
+			opts: UnifiedOptions{Context: 3},
+			exp: `--- old
++++ new
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+		{
+			name: "color",
+			a:    "a\nb\n",
+			b:    "a\nc\n",
+			opts: UnifiedOptions{Context: 1, Color: true},
+			exp: "\x1b[1m--- old\x1b[0m\n\x1b[1m+++ new\x1b[0m\n\x1b[36m@@ -1,2 +1,2 @@\x1b[0m\n" +
+				" a\n\x1b[31m-b\x1b[0m\n\x1b[32m+c\x1b[0m\n",
+		},
+		{
+			name: "words",
+			a:    "x := f(a, b)\n",
+			b:    "x := g(a, b)\n",
+			opts: UnifiedOptions{Color: true, Words: true},
+			exp: "\x1b[1m--- old\x1b[0m\n\x1b[1m+++ new\x1b[0m\n\x1b[36m@@ -1 +1 @@\x1b[0m\n" +
+				"\x1b[31m-x := \x1b[7mf\x1b[27m(a, b)\x1b[0m\n" +
+				"\x1b[32m+x := \x1b[7mg\x1b[27m(a, b)\x1b[0m\n",
+		},
+		{
+			name: "words with nothing in common",
+			a:    "a b\n",
+			b:    "c d\n",
+			opts: UnifiedOptions{Color: true, Words: true},
+			exp: "\x1b[1m--- old\x1b[0m\n\x1b[1m+++ new\x1b[0m\n\x1b[36m@@ -1 +1 @@\x1b[0m\n" +
+				"\x1b[31m-a b\x1b[0m\n\x1b[32m+c d\x1b[0m\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := Unified("old", "new", []byte(tc.a), []byte(tc.b), tc.opts)
+			require.Equal(t, tc.exp, string(out))
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UnifiedOptions control the output of Unified.
+type UnifiedOptions struct {
+	// Context is the number of unchanged lines shown around each change.
+	Context int
+	// Color highlights the output with ANSI escape sequences.
+	Color bool
+	// Words highlights the words that changed within changed lines. It only
+	// has an effect with Color.
+	Words bool
+}
+
+// ANSI escape sequences used to highlight the output of Unified.
+const (
+	colorReset  = "\x1b[0m"
+	colorHeader = "\x1b[1m"
+	colorHunk   = "\x1b[36m"
+	colorDel    = "\x1b[31m"
+	colorIns    = "\x1b[32m"
+	colorWord   = "\x1b[7m"
+	colorNoWord = "\x1b[27m"
+)
+
+// Unified returns the differences between the texts a and b in the unified
+// format, labeled with oldName and newName. It returns nil if the texts are
+// equal.
+func Unified(oldName, newName string, a, b []byte, opts UnifiedOptions) []byte {
+	la, lb := Lines(a), Lines(b)
+	changes := Diff(la, lb)
+	if len(changes) == 0 {
+		return nil
+	}
+	u := unified{a: la, b: lb, opts: opts}
+	u.line(colorHeader, "--- ", []byte(oldName+"\n"), nil)
+	u.line(colorHeader, "+++ ", []byte(newName+"\n"), nil)
+
+	// Changes that are separated by at most twice the context are shown in
+	// the same hunk.
+	for len(changes) > 0 {
+		n := 1
+		for n < len(changes) {
+			prev := changes[n-1]
+			if changes[n].A-(prev.A+prev.Del) > 2*opts.Context {
+				break
+			}
+			n++
+		}
+		u.hunk(changes[:n])
+		changes = changes[n:]
+	}
+	return u.buf.Bytes()
+}
+
+// unified holds the state of a call to Unified.
+type unified struct {
+	a, b [][]byte
+	opts UnifiedOptions
+	buf  bytes.Buffer
+}
+
+// hunk writes a hunk that shows the given changes, along with the unchanged
+// lines around and between them.
+func (u *unified) hunk(changes []Change) {
+	first, last := changes[0], changes[len(changes)-1]
+	aStart := first.A - u.opts.Context
+	if aStart < 0 {
+		aStart = 0
+	}
+	aEnd := last.A + last.Del + u.opts.Context
+	if aEnd > len(u.a) {
+		aEnd = len(u.a)
+	}
+	bStart := first.B - (first.A - aStart)
+	bEnd := last.B + last.Ins + (aEnd - (last.A + last.Del))
+
+	header := fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(aStart, aEnd), hunkRange(bStart, bEnd))
+	u.line(colorHunk, "", []byte(header), nil)
+	i := aStart
+	for _, c := range changes {
+		for ; i < c.A; i++ {
+			u.line("", " ", u.a[i], nil)
+		}
+		del, ins := u.a[c.A:c.A+c.Del], u.b[c.B:c.B+c.Ins]
+		var delMask, insMask []bool
+		if u.opts.Color && u.opts.Words {
+			delMask, insMask = changedWords(del, ins)
+		}
+		for _, l := range del {
+			u.line(colorDel, "-", l, delMask)
+			if delMask != nil {
+				delMask = delMask[len(l):]
+			}
+		}
+		for _, l := range ins {
+			u.line(colorIns, "+", l, insMask)
+			if insMask != nil {
+				insMask = insMask[len(l):]
+			}
+		}
+		i = c.A + c.Del
+	}
+	for ; i < aEnd; i++ {
+		u.line("", " ", u.a[i], nil)
+	}
+}
+
+// hunkRange formats the lines [start, end) for a hunk header. An empty range
+// is given by the line before it.
+func hunkRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, end-start)
+	}
+}
+
+// line writes a line of output that starts with prefix. With color, the line
+// is highlighted with the escape sequence color, and the bytes of l that are
+// set in mask are highlighted further. A line without a trailing newline is
+// followed by a marker.
+func (u *unified) line(color, prefix string, l []byte, mask []bool) {
+	text := bytes.TrimSuffix(l, []byte("\n"))
+	if !u.opts.Color || color == "" {
+		u.buf.WriteString(prefix)
+		u.buf.Write(text)
+	} else {
+		u.buf.WriteString(color)
+		u.buf.WriteString(prefix)
+		highlighted := false
+		for i := range text {
+			if mask != nil && mask[i] != highlighted {
+				highlighted = mask[i]
+				if highlighted {
+					u.buf.WriteString(colorWord)
+				} else {
+					u.buf.WriteString(colorNoWord)
+				}
+			}
+			u.buf.WriteByte(text[i])
+		}
+		u.buf.WriteString(colorReset)
+	}
+	u.buf.WriteByte('\n')
+	if len(text) == len(l) {
+		u.buf.WriteString("\\ No newline at end of file\n")
+	}
+}
+
+// changedWords compares the words of the deleted lines del with those of the
+// inserted lines ins, and returns masks of the bytes of each that belong to
+// changed words. It returns nil masks if the lines have no words other than
+// spaces in common, since highlighting every word would add nothing.
+func changedWords(del, ins [][]byte) (delMask, insMask []bool) {
+	if len(del) == 0 || len(ins) == 0 {
+		return nil, nil
+	}
+	delText, insText := bytes.Join(del, nil), bytes.Join(ins, nil)
+	a, b := Words(delText), Words(insText)
+	changes := Diff(a, b)
+	delMask = wordMask(a, changes, func(c Change) (int, int) { return c.A, c.Del })
+	insMask = wordMask(b, changes, func(c Change) (int, int) { return c.B, c.Ins })
+	for i, c := range delText {
+		if !delMask[i] && !unicode.IsSpace(rune(c)) {
+			return delMask, insMask
+		}
+	}
+	return nil, nil
+}
+
+// wordMask returns a mask of the bytes of the words that are changed on one
+// side of changes, given by side.
+func wordMask(words [][]byte, changes []Change, side func(Change) (start, n int)) []bool {
+	offsets := make([]int, len(words)+1)
+	for i, w := range words {
+		offsets[i+1] = offsets[i] + len(w)
+	}
+	mask := make([]bool, offsets[len(words)])
+	for _, c := range changes {
+		start, n := side(c)
+		for j := offsets[start]; j < offsets[start+n]; j++ {
+			mask[j] = true
+		}
+	}
+	return mask
+}
+
+// Words splits text into words: runs of letters, digits, and underscores, runs
+// of spaces and tabs, and single other characters, including newlines.
+func Words(text []byte) [][]byte {
+	var words [][]byte
+	for len(text) > 0 {
+		r, n := utf8.DecodeRune(text)
+		var class func(rune) bool
+		switch {
+		case isWordRune(r):
+			class = isWordRune
+		case r == ' ' || r == '\t':
+			class = func(r rune) bool { return r == ' ' || r == '\t' }
+		}
+		if class != nil {
+			for n < len(text) {
+				r, size := utf8.DecodeRune(text[n:])
+				if !class(r) {
+					break
+				}
+				n += size
+			}
+		}
+		words = append(words, text[:n])
+		text = text[n:]
+	}
+	return words
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
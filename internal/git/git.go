@@ -0,0 +1,314 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package git queries a local git repository by running the git binary. It
+// never contacts a remote.
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// run runs git with args in dir and returns its standard output.
+func run(dir string, args ...string) ([]byte, error) {
+	return runInput(dir, nil, args...)
+}
+
+// runInput is like run, but also passes stdin to git as its standard input.
+func runInput(dir string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// base returns the commit that the working tree is compared to: the merge
+// base of rev and HEAD, or HEAD itself if rev is empty.
+func base(dir, rev string) (string, error) {
+	if rev == "" {
+		return "HEAD", nil
+	}
+	out, err := run(dir, "merge-base", rev, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// diffArgs returns the arguments to git diff that list the files within paths
+// that were added or modified. The arguments in args, which end with the
+// commits to compare, come before the paths.
+func diffArgs(paths []string, args ...string) []string {
+	args = append([]string{
+		"diff", "--no-renames", "--diff-filter=AM", "--relative", "--no-color", "--no-ext-diff",
+	}, args...)
+	args = append(args, "--")
+	return append(args, paths...)
+}
+
+// ChangedFiles returns the files within paths that were added or modified in
+// the working tree of the repository in dir since the merge base of rev and
+// HEAD, or since HEAD if rev is empty. Files that were renamed are reported
+// as added, and so are untracked files that git does not ignore. The paths,
+// both given and returned, are relative to dir.
+func ChangedFiles(dir, rev string, paths []string) ([]string, error) {
+	b, err := base(dir, rev)
+	if err != nil {
+		return nil, err
+	}
+	out, err := run(dir, diffArgs(paths, "--name-only", "-z", b)...)
+	if err != nil {
+		return nil, err
+	}
+	added, err := untracked(dir, paths)
+	if err != nil {
+		return nil, err
+	}
+	files := append(splitNUL(out), added...)
+	sort.Strings(files)
+	return files, nil
+}
+
+// untracked returns the files within paths in the working tree of the
+// repository in dir that git neither tracks nor ignores. Unlike the files
+// that git diff lists, they have not been added to the index.
+func untracked(dir string, paths []string) ([]string, error) {
+	args := append([]string{"ls-files", "--others", "--exclude-standard", "-z", "--"}, paths...)
+	out, err := run(dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	return splitNUL(out), nil
+}
+
+// A Range is a range of lines, numbered from 1, that includes both Start and
+// End.
+type Range struct {
+	Start, End int
+}
+
+// FileLines holds the changed lines of a file.
+type FileLines struct {
+	Path  string
+	Lines []Range
+}
+
+// ChangedLines is like ChangedFiles, but also returns the lines of each file
+// that were added or modified. Where lines were only deleted, the line before
+// the deletion is reported as changed, so that the code around the deletion
+// counts as changed. Every line of an untracked file counts as added.
+func ChangedLines(dir, rev string, paths []string) ([]FileLines, error) {
+	b, err := base(dir, rev)
+	if err != nil {
+		return nil, err
+	}
+	out, err := run(dir, diffArgs(paths, "-U0", "--no-prefix", b)...)
+	if err != nil {
+		return nil, err
+	}
+	files, err := parseHunks(out)
+	if err != nil {
+		return nil, err
+	}
+	added, err := untracked(dir, paths)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range added {
+		content, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			return nil, err
+		}
+		n := bytes.Count(content, []byte("\n"))
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			n++
+		}
+		if n == 0 {
+			n = 1
+		}
+		files = append(files, FileLines{Path: path, Lines: []Range{{Start: 1, End: n}}})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// StagedLines is like ChangedLines, but compares the index, rather than the
+// working tree, with HEAD. In a repository without commits, every line in the
+// index counts as added.
+func StagedLines(dir string, paths []string) ([]FileLines, error) {
+	args := cachedArgs(dir, "-U0", "--no-prefix")
+	out, err := run(dir, diffArgs(paths, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseHunks(out)
+}
+
+// A StagedFile is a file in the index.
+type StagedFile struct {
+	// Path is relative to the directory that the file was listed from.
+	Path string
+	// Mode is the octal file mode of the file, as recorded in the index.
+	Mode string
+	// Blob is the object name of the file's contents.
+	Blob string
+}
+
+// StagedFiles returns the files within paths that were added or modified in
+// the index of the repository in dir relative to HEAD. In a repository without
+// commits, every file in the index counts as added.
+func StagedFiles(dir string, paths []string) ([]StagedFile, error) {
+	args := cachedArgs(dir, "--raw", "--no-abbrev", "-z")
+	out, err := run(dir, diffArgs(paths, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	// Each file is reported as ":srcmode dstmode srcblob dstblob status",
+	// followed by the path, each terminated by NUL.
+	fields := strings.Split(string(out), "\x00")
+	var files []StagedFile
+	for i := 0; i+1 < len(fields); i += 2 {
+		info := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(info) != 5 {
+			return nil, fmt.Errorf("parsing git diff: invalid entry %q", fields[i])
+		}
+		files = append(files, StagedFile{Path: fields[i+1], Mode: info[1], Blob: info[3]})
+	}
+	return files, nil
+}
+
+// cachedArgs returns the arguments to git diff, ending with the commit to
+// compare with, that compare the index of the repository in dir with HEAD. The
+// arguments in args come after --cached.
+func cachedArgs(dir string, args ...string) []string {
+	args = append([]string{"--cached"}, args...)
+	// Without HEAD, git diff --cached compares the index with an empty tree.
+	if _, err := run(dir, "rev-parse", "--verify", "--quiet", "HEAD"); err == nil {
+		args = append(args, "HEAD")
+	}
+	return args
+}
+
+// ReadBlob returns the contents of blob.
+func ReadBlob(dir, blob string) ([]byte, error) {
+	return run(dir, "cat-file", "blob", blob)
+}
+
+// indexMu serializes updates to the index, which git locks while it writes
+// it.
+var indexMu sync.Mutex
+
+// WriteStaged replaces the contents of f in the index with content. The
+// working tree is not modified. It is safe to call concurrently.
+func WriteStaged(dir string, f StagedFile, content []byte) error {
+	out, err := runInput(dir, content, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	blob := strings.TrimSpace(string(out))
+	// Unlike other paths, the path given to --cacheinfo is relative to the
+	// root of the repository.
+	prefix, err := Prefix(dir)
+	if err != nil {
+		return err
+	}
+	path := prefix + f.Path
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	_, err = run(dir, "update-index", "--cacheinfo", f.Mode+","+blob+","+path)
+	return err
+}
+
+// Prefix returns the slash-separated path of dir relative to the root of its
+// repository, with a trailing slash, or "" if dir is the root.
+func Prefix(dir string) (string, error) {
+	out, err := run(dir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// parseHunks parses the output of git diff -U0 --no-prefix.
+func parseHunks(out []byte) ([]FileLines, error) {
+	var files []FileLines
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			path := strings.TrimPrefix(line, "+++ ")
+			if strings.HasPrefix(path, `"`) {
+				var err error
+				if path, err = strconv.Unquote(path); err != nil {
+					return nil, fmt.Errorf("parsing git diff: invalid path %s", line)
+				}
+			}
+			files = append(files, FileLines{Path: path})
+
+		case strings.HasPrefix(line, "@@ "):
+			// The header is @@ -start[,count] +start[,count] @@.
+			fields := strings.Fields(line)
+			if len(files) == 0 || len(fields) < 4 || !strings.HasPrefix(fields[2], "+") {
+				return nil, fmt.Errorf("parsing git diff: invalid hunk header %s", line)
+			}
+			startStr, countStr, hasCount := strings.Cut(fields[2][1:], ",")
+			start, err := strconv.Atoi(startStr)
+			count := 1
+			if err == nil && hasCount {
+				count, err = strconv.Atoi(countStr)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing git diff: invalid hunk header %s", line)
+			}
+			r := Range{Start: start, End: start + count - 1}
+			if count == 0 {
+				// Lines were deleted after line start.
+				r = Range{Start: start, End: start}
+				if start == 0 {
+					r = Range{Start: 1, End: 1}
+				}
+			}
+			f := &files[len(files)-1]
+			f.Lines = append(f.Lines, r)
+		}
+	}
+	return files, nil
+}
+
+// splitNUL splits NUL-terminated strings.
+func splitNUL(b []byte) []string {
+	var out []string
+	for _, s := range strings.Split(string(b), "\x00") {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
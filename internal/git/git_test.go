@@ -0,0 +1,202 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// repo is a temporary git repository.
+type repo struct {
+	t   *testing.T
+	dir string
+}
+
+func newRepo(t *testing.T) *repo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	r := &repo{t: t, dir: t.TempDir()}
+	r.git("init", "-q", "-b", "main")
+	r.git("config", "user.name", "test")
+	r.git("config", "user.email", "test@example.com")
+	return r
+}
+
+func (r *repo) git(args ...string) string {
+	out, err := run(r.dir, args...)
+	require.NoError(r.t, err)
+	return string(out)
+}
+
+func (r *repo) write(name, content string) {
+	path := filepath.Join(r.dir, name)
+	require.NoError(r.t, os.MkdirAll(filepath.Dir(path), 0777))
+	require.NoError(r.t, os.WriteFile(path, []byte(content), 0666))
+}
+
+func (r *repo) commit(msg string) {
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", msg)
+}
+
+func TestChangedFiles(t *testing.T) {
+	r := newRepo(t)
+	r.write("a.go", "package a\n")
+	r.write("b.go", "package a\n")
+	r.write("c.go", "package a\n")
+	r.write("d.go", "package a\n")
+	r.write("sub/e.go", "package sub\n")
+	r.commit("base")
+
+	r.git("checkout", "-q", "-b", "feature")
+	r.write("a.go", "package a // modified\n")
+	r.git("rm", "-q", "b.go")
+	r.git("mv", "c.go", "renamed.go")
+	r.write("new.go", "package a\n")
+	r.commit("feature")
+
+	// Commits on the other branch after the merge base are not included.
+	r.git("checkout", "-q", "main")
+	r.write("d.go", "package a // modified on main\n")
+	r.commit("main")
+	r.git("checkout", "-q", "feature")
+
+	// Uncommitted changes are included too.
+	r.write("sub/e.go", "package sub // modified\n")
+	r.write("sub/f file.go", "package sub\n")
+	r.git("add", "sub/f file.go")
+
+	// So are untracked files, unless they are ignored.
+	r.write("sub/untracked.go", "package sub\n")
+	r.write("ignored.go", "package a\n")
+	r.write(".git/info/exclude", "ignored.go\n")
+
+	files, err := ChangedFiles(r.dir, "main", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.go", "new.go", "renamed.go", "sub/e.go", "sub/f file.go", "sub/untracked.go"}, files)
+
+	files, err = ChangedFiles(filepath.Join(r.dir, "sub"), "main", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"e.go", "f file.go", "untracked.go"}, files)
+
+	files, err = ChangedFiles(r.dir, "main", []string{"sub"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"sub/e.go", "sub/f file.go", "sub/untracked.go"}, files)
+
+	_, err = ChangedFiles(r.dir, "nonexistent", nil)
+	require.Error(t, err)
+}
+
+func TestChangedLines(t *testing.T) {
+	r := newRepo(t)
+	r.write("a.go", "1\n2\n3\n4\n5\n6\n7\n8\n")
+	r.write("b.go", "1\n2\n")
+	r.write("é.go", "1\n")
+	r.commit("base")
+
+	r.write("a.go", "1\nnew\n2\n3\n5\n6\nsix\nseven\n8\n")
+	r.write("b.go", "2\n")
+	r.write("é.go", "1\n2\n")
+	r.write("c.go", "1\n")
+	r.git("add", "c.go")
+	r.write("d.go", "1\n2\n3")
+
+	files, err := ChangedLines(r.dir, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, []FileLines{
+		// Line 2 was added, 4 was deleted after line 4 of the new file, and
+		// 7 was replaced by lines 7 and 8.
+		{Path: "a.go", Lines: []Range{{2, 2}, {4, 4}, {7, 8}}},
+		{Path: "b.go", Lines: []Range{{1, 1}}},
+		{Path: "c.go", Lines: []Range{{1, 1}}},
+		// Every line of an untracked file is added.
+		{Path: "d.go", Lines: []Range{{1, 3}}},
+		{Path: "é.go", Lines: []Range{{2, 2}}},
+	}, files)
+
+	files2, err := ChangedFiles(r.dir, "", nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.go", "b.go", "c.go", "d.go", "é.go"}, files2)
+}
+
+func TestStaged(t *testing.T) {
+	r := newRepo(t)
+	r.write("a.go", "1\n2\n")
+	r.write("b.go", "1\n")
+	// Files are listed even before the first commit.
+	r.git("add", "a.go", "b.go")
+	files, err := StagedFiles(r.dir, nil)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	lines, err := StagedLines(r.dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []FileLines{
+		{Path: "a.go", Lines: []Range{{1, 2}}},
+		{Path: "b.go", Lines: []Range{{1, 1}}},
+	}, lines)
+	r.commit("base")
+
+	r.write("a.go", "1\n2\nstaged\n")
+	r.git("add", "a.go")
+	r.write("a.go", "1\n2\nstaged\nunstaged\n")
+	r.write("b.go", "1\nunstaged\n")
+	r.write("sub/c.go", "new\n")
+	r.git("add", "sub/c.go")
+
+	files, err = StagedFiles(r.dir, nil)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "a.go", files[0].Path)
+	require.Equal(t, "100644", files[0].Mode)
+	require.Equal(t, "sub/c.go", files[1].Path)
+	content, err := ReadBlob(r.dir, files[0].Blob)
+	require.NoError(t, err)
+	require.Equal(t, "1\n2\nstaged\n", string(content))
+
+	lines, err = StagedLines(r.dir, nil)
+	require.NoError(t, err)
+	require.Equal(t, []FileLines{
+		{Path: "a.go", Lines: []Range{{3, 3}}},
+		{Path: "sub/c.go", Lines: []Range{{1, 1}}},
+	}, lines)
+
+	// Paths are relative to the directory that files are listed from.
+	files, err = StagedFiles(filepath.Join(r.dir, "sub"), nil)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.NoError(t, WriteStaged(filepath.Join(r.dir, "sub"), files[0], []byte("rewritten\n")))
+	require.Equal(t, "rewritten\n", r.git("show", ":sub/c.go"))
+	// The working tree is left alone.
+	content, err = os.ReadFile(filepath.Join(r.dir, "sub", "c.go"))
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(content))
+}
+
+func TestPrefix(t *testing.T) {
+	r := newRepo(t)
+	r.write("pkg/sql/a.go", "1\n")
+	prefix, err := Prefix(r.dir)
+	require.NoError(t, err)
+	require.Equal(t, "", prefix)
+	prefix, err = Prefix(filepath.Join(r.dir, "pkg", "sql"))
+	require.NoError(t, err)
+	require.Equal(t, "pkg/sql/", prefix)
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package packages resolves Go package patterns, such as ./... or import
+// paths, to the Go files of the matching packages by running go list. Modules
+// are never downloaded.
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Config controls how patterns are resolved.
+type Config struct {
+	// Dir is the directory in which go list runs.
+	Dir string
+	// Tags lists additional build tags to satisfy.
+	Tags []string
+	// AllVariants includes the files that build constraints exclude for the
+	// current GOOS, GOARCH, and tags.
+	AllVariants bool
+}
+
+// pkg holds the fields of go list's output that Files uses.
+type pkg struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *struct {
+		Main bool
+	}
+	GoFiles        []string
+	CgoFiles       []string
+	TestGoFiles    []string
+	XTestGoFiles   []string
+	IgnoredGoFiles []string
+	Error          *struct {
+		Err string
+	}
+}
+
+// Files returns the absolute paths of the Go files, including test files, of
+// the packages matching patterns. Packages in the standard library and in
+// modules other than the main module are skipped.
+func Files(cfg Config, patterns []string) ([]string, error) {
+	args := []string{"list", "-e", "-json"}
+	if len(cfg.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(cfg.Tags, ","))
+	}
+	args = append(args, "--")
+	cmd := exec.Command("go", append(args, patterns...)...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = append(os.Environ(), "GOPROXY=off")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("go list: %s", msg)
+		}
+		return nil, fmt.Errorf("go list: %s", err)
+	}
+
+	var files []string
+	dec := json.NewDecoder(&stdout)
+	for {
+		var p pkg
+		if err := dec.Decode(&p); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("go list: %s", err)
+		}
+		if p.Standard || (p.Module != nil && !p.Module.Main) {
+			continue
+		}
+		if p.Dir == "" {
+			if p.Error != nil {
+				return nil, fmt.Errorf("resolving %s: %s", p.ImportPath, p.Error.Err)
+			}
+			continue
+		}
+		names := [][]string{p.GoFiles, p.CgoFiles, p.TestGoFiles, p.XTestGoFiles}
+		if cfg.AllVariants {
+			names = append(names, p.IgnoredGoFiles)
+		}
+		for _, n := range names {
+			for _, name := range n {
+				files = append(files, filepath.Join(p.Dir, name))
+			}
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package packages
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiles(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":             "module example.com/m\n\ngo 1.19\n",
+		"a/a.go":             "package a\n",
+		"a/a_test.go":        "package a\n",
+		"a/a_x_test.go":      "package a_test\n",
+		"a/tagged.go":        "//go:build special\n\npackage a\n",
+		"a/other_plan9.go":   "package a\n",
+		"a/b/b.go":           "package b\n",
+		"vendor/v/v.go":      "package v\n",
+		"vendor/modules.txt": "",
+		"a/README":           "",
+	} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0666))
+	}
+	rel := func(files []string) []string {
+		for i, f := range files {
+			r, err := filepath.Rel(dir, f)
+			require.NoError(t, err)
+			files[i] = filepath.ToSlash(r)
+		}
+		sort.Strings(files)
+		return files
+	}
+
+	files, err := Files(Config{Dir: dir}, []string{"./..."})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/a.go", "a/a_test.go", "a/a_x_test.go", "a/b/b.go"}, rel(files))
+
+	files, err = Files(Config{Dir: dir, Tags: []string{"special"}}, []string{"example.com/m/a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/a.go", "a/a_test.go", "a/a_x_test.go", "a/tagged.go"}, rel(files))
+
+	files, err = Files(Config{Dir: dir, AllVariants: true}, []string{"./a"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/a.go", "a/a_test.go", "a/a_x_test.go", "a/other_plan9.go", "a/tagged.go"}, rel(files))
+
+	_, err = Files(Config{Dir: dir}, []string{"example.com/m/nonexistent"})
+	require.Error(t, err)
+}
@@ -29,6 +29,7 @@ type File struct {
 
 	file *ast.File
 	fset *token.FileSet
+	tok  *token.File
 	src  []byte
 }
 
@@ -41,7 +42,13 @@ func ParseFile(name string, src []byte) (*File, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewFile(fset, file, src), nil
+}
 
+// NewFile returns the File corresponding to file, which must have been parsed
+// from src into fset with comments. It allows a syntax tree that has already
+// been parsed to be reused rather than parsing src again.
+func NewFile(fset *token.FileSet, file *ast.File, src []byte) *File {
 	cr := commentListReader{fset: fset, comments: file.Comments}
 
 	var decls []Decl
@@ -101,8 +108,19 @@ func ParseFile(name string, src []byte) (*File, error) {
 		Decls: decls,
 		file:  file,
 		fset:  fset,
+		tok:   fset.File(file.Pos()),
 		src:   src,
-	}, nil
+	}
+}
+
+// Name returns the name of the file, as passed to ParseFile.
+func (f *File) Name() string {
+	return f.tok.Name()
+}
+
+// Src returns the source code of the file.
+func (f *File) Src() []byte {
+	return f.src
 }
 
 // Position converts a token.Pos into a token.Position.
@@ -112,12 +130,12 @@ func (f *File) Position(p token.Pos) token.Position {
 
 // Pos converts a file offset into a token.Pos.
 func (f *File) Pos(offset int) token.Pos {
-	return f.fset.File(1).Pos(offset)
+	return f.tok.Pos(offset)
 }
 
 // Offset converts a token.Pos into a file offset.
 func (f *File) Offset(p token.Pos) int {
-	return f.Position(p).Offset
+	return f.tok.Offset(p)
 }
 
 // Slice returns the bytes in the range [start, end).
@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
-	"go/token"
 	"io"
 	"sort"
 	"strings"
@@ -130,21 +129,15 @@ func renderLineFuncField(w io.Writer, f *parser.File, param *ast.Field) {
 	fmt.Fprintln(w)
 }
 
-// Func renders the function fn into w. The function is wrapped so that no line
-// exceeds past the wrap column wrapCol when tabs are rendered with specified
-// tab size.
-func Func(w io.Writer, f *parser.File, fn *parser.FuncDecl, tabSize, wrapBody, wrapDocString int, lastPos token.Pos) {
+// Func renders the signature of the function fn into w, from just after the
+// opening parenthesis of its parameter list through the end of its results.
+// The signature is wrapped so that no line exceeds past the wrap column
+// wrapBody when tabs are rendered with specified tab size.
+func Func(w io.Writer, f *parser.File, fn *parser.FuncDecl, tabSize, wrapBody int) {
 	params := fn.Type.Params
 	results := fn.Type.Results
 
-	if fn.Doc != nil {
-		DocString(w, f, fn.Doc, wrapDocString, lastPos, fn.Type.Pos())
-	} else {
-		w.Write(f.Slice(lastPos, fn.Type.Pos()))
-	}
-
 	opening := params.Pos() + 1
-	closing := fn.BodyEnd()
 
 	var paramsBuf bytes.Buffer
 	if params != nil {
@@ -204,7 +197,6 @@ func Func(w io.Writer, f *parser.File, fn *parser.FuncDecl, tabSize, wrapBody, w
 		}
 	}
 
-	w.Write(f.Slice(fn.Pos(), opening))
 	// colOffset - 1 accounts for `func (r *foo) bar(`
 	colOffset := f.Position(opening).Column - 1
 	singleLineLen := colOffset + len(paramsJoined) + len(funcMid) + len(resultsJoined) + len(funcEnd) + brace
@@ -242,23 +234,12 @@ func Func(w io.Writer, f *parser.File, fn *parser.FuncDecl, tabSize, wrapBody, w
 			fmt.Fprint(w, funcEnd)
 		}
 	}
-	w.Write(f.Slice(fn.Type.End(), closing))
-}
-
-func GenDecl(w io.Writer, f *parser.File, decl ast.GenDecl, wrapDocString int, lastPos token.Pos) {
-	if decl.Doc != nil {
-		DocString(w, f, decl.Doc, wrapDocString, lastPos, decl.TokPos)
-		w.Write(f.Slice(decl.TokPos, decl.End()))
-	} else {
-		w.Write(f.Slice(lastPos, decl.End()))
-	}
 }
 
-// DocString renders a docstring from lastPos to nextPos where lastPos is
-// the end of the previous Decl and next pos is the start of the Decl
-// corresponding to this doc string.
-func DocString(w io.Writer, f *parser.File, doc *ast.CommentGroup, wrapDocString int, lastPos, nextPos token.Pos) {
-	w.Write(f.Slice(lastPos, doc.Pos()))
+// DocString renders the doc comment doc into w. Lines that exceed the wrap
+// column wrapDocString are wrapped. Multiline comments denoted by /* are
+// rendered unchanged.
+func DocString(w io.Writer, f *parser.File, doc *ast.CommentGroup, wrapDocString int) {
 	if strings.Fields(doc.List[0].Text)[0] != "/*" {
 		for i, c := range doc.List {
 			if len(c.Text) <= wrapDocString {
@@ -301,6 +282,4 @@ func DocString(w io.Writer, f *parser.File, doc *ast.CommentGroup, wrapDocString
 		// Multiline comments are unchanged for now.
 		w.Write(f.Slice(doc.Pos(), doc.End()))
 	}
-
-	w.Write(f.Slice(doc.End(), nextPos))
 }
@@ -14,12 +14,15 @@ import (
 	"unicode/utf8"
 )
 
-func Simplify(f *ast.File) {
+// Simplify applies the simplifications of gofmt -s to f. It reports whether
+// f was modified.
+func Simplify(f *ast.File) bool {
 	// remove empty declarations such as "const ()", etc
-	removeEmptyDeclGroups(f)
+	removed := removeEmptyDeclGroups(f)
 
-	var s simplifier
+	s := &simplifier{}
 	ast.Walk(s, f)
+	return removed || s.changed
 }
 
 // Values/types for special cases.
@@ -126,9 +129,11 @@ func match(m map[string]reflect.Value, pattern, val reflect.Value) bool {
 	return p.Interface() == v.Interface()
 }
 
-type simplifier struct{}
+type simplifier struct {
+	changed bool // whether any node was simplified
+}
 
-func (s simplifier) Visit(node ast.Node) ast.Visitor {
+func (s *simplifier) Visit(node ast.Node) ast.Visitor {
 	switch n := node.(type) {
 	case *ast.CompositeLit:
 		// array, slice, and map composite literals may be simplified
@@ -177,16 +182,17 @@ func (s simplifier) Visit(node ast.Node) ast.Visitor {
 			// - 3-index slices always require the 2nd and 3rd index
 			break
 		}
-		if s, _ := n.X.(*ast.Ident); s != nil && s.Obj != nil {
+		if x, _ := n.X.(*ast.Ident); x != nil && x.Obj != nil {
 			// the array/slice object is a single, resolved identifier
 			if call, _ := n.High.(*ast.CallExpr); call != nil && len(call.Args) == 1 && !call.Ellipsis.IsValid() {
 				// the high expression is a function call with a single argument
 				if fun, _ := call.Fun.(*ast.Ident); fun != nil && fun.Name == "len" && fun.Obj == nil {
 					// the function called is "len" and it is not locally defined; and
 					// because we don't have dot imports, it must be the predefined len()
-					if arg, _ := call.Args[0].(*ast.Ident); arg != nil && arg.Obj == s.Obj {
+					if arg, _ := call.Args[0].(*ast.Ident); arg != nil && arg.Obj == x.Obj {
 						// the len argument is the array/slice object
 						n.High = nil
+						s.changed = true
 					}
 				}
 			}
@@ -206,16 +212,18 @@ func (s simplifier) Visit(node ast.Node) ast.Visitor {
 		// can be simplified to: for range v {...}
 		if isBlank(n.Value) {
 			n.Value = nil
+			s.changed = true
 		}
 		if isBlank(n.Key) && n.Value == nil {
 			n.Key = nil
+			s.changed = true
 		}
 	}
 
 	return s
 }
 
-func (s simplifier) simplifyLiteral(typ reflect.Value, astType, x ast.Expr, px *ast.Expr) {
+func (s *simplifier) simplifyLiteral(typ reflect.Value, astType, x ast.Expr, px *ast.Expr) {
 	ast.Walk(s, x) // simplify x
 
 	// if the element is a composite literal and its literal type
@@ -224,6 +232,7 @@ func (s simplifier) simplifyLiteral(typ reflect.Value, astType, x ast.Expr, px *
 	if inner, ok := x.(*ast.CompositeLit); ok {
 		if match(nil, typ, reflect.ValueOf(inner.Type)) {
 			inner.Type = nil
+			s.changed = true
 		}
 	}
 	// if the outer literal's element type is a pointer type *T
@@ -235,6 +244,7 @@ func (s simplifier) simplifyLiteral(typ reflect.Value, astType, x ast.Expr, px *
 				if match(nil, reflect.ValueOf(ptr.X), reflect.ValueOf(inner.Type)) {
 					inner.Type = nil // drop T
 					*px = inner      // drop &
+					s.changed = true
 				}
 			}
 		}
@@ -246,15 +256,7 @@ func isBlank(x ast.Expr) bool {
 	return ok && ident.Name == "_"
 }
 
-func simplify(f *ast.File) {
-	// remove empty declarations such as "const ()", etc
-	removeEmptyDeclGroups(f)
-
-	var s simplifier
-	ast.Walk(s, f)
-}
-
-func removeEmptyDeclGroups(f *ast.File) {
+func removeEmptyDeclGroups(f *ast.File) bool {
 	i := 0
 	for _, d := range f.Decls {
 		if g, ok := d.(*ast.GenDecl); !ok || !isEmpty(f, g) {
@@ -262,7 +264,9 @@ func removeEmptyDeclGroups(f *ast.File) {
 			i++
 		}
 	}
+	removed := i < len(f.Decls)
 	f.Decls = f.Decls[:i]
+	return removed
 }
 
 func isEmpty(f *ast.File, g *ast.GenDecl) bool {
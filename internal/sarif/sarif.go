@@ -0,0 +1,255 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package sarif writes the changes that crlfmt would make to files as a log in
+// the Static Analysis Results Interchange Format (SARIF), version 2.1.0, for
+// code scanning tools. Each change is a result, with a fix that makes it.
+package sarif
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/cockroachdb/crlfmt/internal/diff"
+)
+
+// Schema is the URI of the JSON schema of the logs written by Write.
+const Schema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// A Rule is a kind of change, such as the changes made by a pass.
+type Rule struct {
+	ID          string
+	Description string
+}
+
+// A Change is a change that formatting makes to a file.
+type Change struct {
+	// RuleID is the ID of the rule that the change belongs to.
+	RuleID string
+	// Message describes the change.
+	Message string
+	// Hunk gives the lines of the file that the change replaces, and the
+	// lines of the formatted file that replace them.
+	Hunk format.Hunk
+}
+
+// An Error is an error that prevented a file from being formatted. Line and
+// Column are zero if the error has no position.
+type Error struct {
+	Line, Column int
+	Message      string
+}
+
+// A File is a file that crlfmt checked.
+type File struct {
+	// Path is the slash-separated path of the file, relative to the root of
+	// the run.
+	Path string
+	// Src is the contents of the file, and Out its formatted contents.
+	Src, Out []byte
+	Changes  []Change
+	Errors   []Error
+}
+
+// Write writes a log of a run of crlfmt that checked files to w. The rules
+// list every rule that changes may belong to.
+func Write(w io.Writer, rules []Rule, files []File) error {
+	r := run{
+		Tool: tool{Driver: driver{
+			Name:           "crlfmt",
+			InformationURI: "https://github.com/cockroachdb/crlfmt",
+		}},
+		Invocations: []invocation{{ExecutionSuccessful: true}},
+		Results:     []result{},
+	}
+	ruleIndex := make(map[string]int, len(rules))
+	for i, rule := range rules {
+		ruleIndex[rule.ID] = i
+		r.Tool.Driver.Rules = append(r.Tool.Driver.Rules, reportingDescriptor{
+			ID:               rule.ID,
+			ShortDescription: &message{Text: rule.Description},
+		})
+	}
+	for _, f := range files {
+		loc := artifactLocation{URI: (&url.URL{Path: f.Path}).String()}
+		for _, e := range f.Errors {
+			n := notification{Level: "error", Message: message{Text: e.Message}}
+			pl := physicalLocation{ArtifactLocation: loc}
+			if e.Line > 0 {
+				pl.Region = &region{StartLine: e.Line, StartColumn: e.Column}
+			}
+			n.Locations = []location{{PhysicalLocation: pl}}
+			inv := &r.Invocations[0]
+			inv.ExecutionSuccessful = false
+			inv.ToolExecutionNotifications = append(inv.ToolExecutionNotifications, n)
+		}
+		if len(f.Changes) == 0 {
+			continue
+		}
+		src, out := diff.Lines(f.Src), diff.Lines(f.Out)
+		srcOff, outOff := offsets(src), offsets(out)
+		for _, c := range f.Changes {
+			h := c.Hunk
+			res := result{
+				RuleID:  c.RuleID,
+				Level:   "warning",
+				Message: message{Text: c.Message},
+				Locations: []location{{PhysicalLocation: physicalLocation{
+					ArtifactLocation: loc,
+					Region:           lineRegion(h, len(src)),
+				}}},
+			}
+			if i, ok := ruleIndex[c.RuleID]; ok {
+				res.RuleIndex = &i
+			}
+
+			// The fix replaces the bytes of the old lines, so that it also
+			// handles a last line without a newline.
+			start, end := srcOff[h.OldStart-1], srcOff[h.OldStart-1+h.OldLines]
+			rep := replacement{DeletedRegion: region{ByteOffset: &start, ByteLength: end - start}}
+			if h.NewLines > 0 {
+				text := string(f.Out[outOff[h.NewStart-1]:outOff[h.NewStart-1+h.NewLines]])
+				rep.InsertedContent = &artifactContent{Text: text}
+			}
+			res.Fixes = []fix{{
+				Description: message{Text: c.Message},
+				ArtifactChanges: []artifactChange{{
+					ArtifactLocation: loc,
+					Replacements:     []replacement{rep},
+				}},
+			}}
+			r.Results = append(r.Results, res)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&log{Schema: Schema, Version: "2.1.0", Runs: []run{r}})
+}
+
+// lineRegion returns the region of the lines that the hunk h replaces in a
+// file with n lines. The region of a hunk that inserts lines has no end line.
+func lineRegion(h format.Hunk, n int) *region {
+	start, end := h.OldRange(n)
+	if h.OldLines == 0 {
+		return &region{StartLine: start}
+	}
+	return &region{StartLine: start, EndLine: end}
+}
+
+// offsets returns the offset of the start of each line, followed by the
+// length of the text.
+func offsets(lines [][]byte) []int {
+	offsets := make([]int, len(lines)+1)
+	for i, l := range lines {
+		offsets[i+1] = offsets[i] + len(l)
+	}
+	return offsets
+}
+
+// The following types are the parts of the SARIF object model that crlfmt
+// uses.
+
+type log struct {
+	Schema  string `json:"$schema"`
+	Version string `json:"version"`
+	Runs    []run  `json:"runs"`
+}
+
+type run struct {
+	Tool        tool         `json:"tool"`
+	Invocations []invocation `json:"invocations"`
+	Results     []result     `json:"results"`
+}
+
+type tool struct {
+	Driver driver `json:"driver"`
+}
+
+type driver struct {
+	Name           string                `json:"name"`
+	InformationURI string                `json:"informationUri"`
+	Rules          []reportingDescriptor `json:"rules,omitempty"`
+}
+
+type reportingDescriptor struct {
+	ID               string   `json:"id"`
+	ShortDescription *message `json:"shortDescription,omitempty"`
+}
+
+type invocation struct {
+	ExecutionSuccessful        bool           `json:"executionSuccessful"`
+	ToolExecutionNotifications []notification `json:"toolExecutionNotifications,omitempty"`
+}
+
+type notification struct {
+	Level     string     `json:"level"`
+	Message   message    `json:"message"`
+	Locations []location `json:"locations,omitempty"`
+}
+
+type result struct {
+	RuleID    string     `json:"ruleId,omitempty"`
+	RuleIndex *int       `json:"ruleIndex,omitempty"`
+	Level     string     `json:"level"`
+	Message   message    `json:"message"`
+	Locations []location `json:"locations"`
+	Fixes     []fix      `json:"fixes"`
+}
+
+type message struct {
+	Text string `json:"text"`
+}
+
+type location struct {
+	PhysicalLocation physicalLocation `json:"physicalLocation"`
+}
+
+type physicalLocation struct {
+	ArtifactLocation artifactLocation `json:"artifactLocation"`
+	Region           *region          `json:"region,omitempty"`
+}
+
+type artifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type region struct {
+	StartLine   int  `json:"startLine,omitempty"`
+	StartColumn int  `json:"startColumn,omitempty"`
+	EndLine     int  `json:"endLine,omitempty"`
+	ByteOffset  *int `json:"byteOffset,omitempty"`
+	ByteLength  int  `json:"byteLength,omitempty"`
+}
+
+type fix struct {
+	Description     message          `json:"description"`
+	ArtifactChanges []artifactChange `json:"artifactChanges"`
+}
+
+type artifactChange struct {
+	ArtifactLocation artifactLocation `json:"artifactLocation"`
+	Replacements     []replacement    `json:"replacements"`
+}
+
+type replacement struct {
+	DeletedRegion   region           `json:"deletedRegion"`
+	InsertedContent *artifactContent `json:"insertedContent,omitempty"`
+}
+
+type artifactContent struct {
+	Text string `json:"text"`
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package sarif
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/stretchr/testify/require"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var rewrite = flag.Bool("rewrite", false, "used to rewrite output")
+
+func rules() []Rule {
+	var rules []Rule
+	for _, p := range format.Passes() {
+		rules = append(rules, Rule{ID: p.Name(), Description: p.Doc()})
+	}
+	return rules
+}
+
+// checkedFile formats the file at path and returns it as a File, with a change
+// for each hunk that belongs to the first pass that made it.
+func checkedFile(t *testing.T, path string) File {
+	src, err := os.ReadFile(path)
+	require.NoError(t, err)
+	opts := format.DefaultOptions()
+	// The output of goimports depends on the version of Go.
+	opts.Skip = []string{"goimports"}
+	out, hunks, err := format.SourceHunks(path, src, opts)
+	require.NoError(t, err)
+	f := File{Path: filepath.ToSlash(path), Src: src, Out: out}
+	for _, h := range hunks {
+		f.Changes = append(f.Changes, Change{
+			RuleID:  h.Passes[0],
+			Message: "would " + h.Passes[0],
+			Hunk:    h,
+		})
+	}
+	return f
+}
+
+// validate checks that data is valid according to the SARIF schema.
+func validate(t *testing.T, data []byte) {
+	schema, err := os.ReadFile(filepath.Join("testdata", "sarif-schema-2.1.0.json"))
+	require.NoError(t, err)
+	res, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schema), gojsonschema.NewBytesLoader(data))
+	require.NoError(t, err)
+	var errs []string
+	for _, e := range res.Errors() {
+		errs = append(errs, e.String())
+	}
+	require.True(t, res.Valid(), "%s", strings.Join(errs, "\n"))
+}
+
+func TestWrite(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.in.go")
+	require.NoError(t, err)
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			f := checkedFile(t, file)
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, rules(), []File{f}))
+			validate(t, buf.Bytes())
+
+			golden := strings.TrimSuffix(file, ".in.go") + ".sarif"
+			if *rewrite {
+				require.NoError(t, os.WriteFile(golden, buf.Bytes(), 0666))
+			} else {
+				exp, err := os.ReadFile(golden)
+				require.NoError(t, err)
+				require.Equal(t, string(exp), buf.String())
+			}
+
+			// Applying the fixes must produce the formatted file.
+			var log struct {
+				Runs []struct {
+					Results []struct {
+						Fixes []fix
+					}
+				}
+			}
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &log))
+			var reps []replacement
+			for _, r := range log.Runs[0].Results {
+				reps = append(reps, r.Fixes[0].ArtifactChanges[0].Replacements...)
+			}
+			sort.Slice(reps, func(i, j int) bool {
+				return *reps[i].DeletedRegion.ByteOffset > *reps[j].DeletedRegion.ByteOffset
+			})
+			out := append([]byte(nil), f.Src...)
+			for _, r := range reps {
+				start := *r.DeletedRegion.ByteOffset
+				var text string
+				if r.InsertedContent != nil {
+					text = r.InsertedContent.Text
+				}
+				out = append(out[:start], append([]byte(text), out[start+r.DeletedRegion.ByteLength:]...)...)
+			}
+			require.Equal(t, string(f.Out), string(out))
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, rules(), []File{
+		{Path: "dir with space/bad.go", Errors: []Error{{Line: 2, Column: 8, Message: "expected ';', found 'EOF'"}}},
+		{Path: "other.go", Errors: []Error{{Message: "no position"}}},
+	}))
+	validate(t, buf.Bytes())
+	require.Contains(t, buf.String(), `"uri": "dir%20with%20space/bad.go"`)
+	require.Contains(t, buf.String(), `"executionSuccessful": false`)
+	require.Contains(t, buf.String(), `"results": []`)
+}
@@ -0,0 +1,15 @@
+package changes
+
+import (
+	"github.com/cockroachdb/crlfmt/format"
+	"fmt"
+)
+
+type point struct{ x, y int }
+
+var points = []point{point{1, 2}, point{3, 4}}
+
+// Describe is a function whose doc comment is long enough that it has to be wrapped by crlfmt, since it exceeds the limit.
+func Describe(hunk format.Hunk, aaaaaaaaaa int, bbbbbbbbbb int, cccccccccc int) (dddddddddd string, eeeeeeeeee error) {
+	return fmt.Sprint(hunk), nil
+}
@@ -0,0 +1,3 @@
+package formatted
+
+var x = 1
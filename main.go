@@ -16,82 +16,312 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"flag"
 	"fmt"
-	goparser "go/parser"
-	"go/printer"
-	"go/token"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 
-	"github.com/cockroachdb/crlfmt/internal/parser"
-	"github.com/cockroachdb/crlfmt/internal/render"
-	"github.com/cockroachdb/gostdlib/go/format"
-	"github.com/cockroachdb/gostdlib/x/tools/imports"
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/cockroachdb/crlfmt/internal/cache"
+	"github.com/cockroachdb/crlfmt/internal/diff"
+	"github.com/cockroachdb/crlfmt/internal/git"
+	"github.com/cockroachdb/crlfmt/internal/packages"
 )
 
-var (
+var defaults = format.DefaultOptions()
+
+// settings holds the values of crlfmt's flags.
+type settings struct {
+	wrapdoc      int
+	wrap         int
+	tab          int
+	overwrite    bool
+	fast         bool
+	groupImports bool
+	printDiff    bool
+	color        string
+	diffContext  int
+	diffWords    bool
+	ignore       string
+	localPrefix  string
+	srcDir       string
+	passes       string
+	skip         string
+	parallelism  int
+	cacheMode    string
+	checkMode    bool
+	list         bool
+	since        string
+	lines        lineRanges
+	gitDiffLines bool
+	staged       bool
+	stdinName    string
+	filesFrom    string
+	tags         string
+	allVariants  bool
+	prune        bool
+	stopAtMods   bool
+	generated    string
+	verbose      bool
+	shard        shard
+	reportFormat string
+	patch        string
+
+	// ignored is set by overrides that skip files.
+	ignored bool
+
+	// The following fields are set by check.
+	ignoreRE *regexp.Regexp
+	opts     format.Options
+	diffOpts diff.UnifiedOptions
+}
+
+// newSettings defines crlfmt's flags in fs and returns the settings that hold
+// their values.
+func newSettings(fs *flag.FlagSet) *settings {
+	s := &settings{}
 	// TODO: wrap doc strings for imports and floating comments.
-	wrapdoc      = flag.Int("wrapdoc", 160, "column at which to wrap doc strings for functions, variables, constants, and types. ignores multiline comments denoted by /*")
-	wrap         = flag.Int("wrap", 100, "column to wrap at")
-	tab          = flag.Int("tab", 2, "tab width for column calculations")
-	overwrite    = flag.Bool("w", false, "overwrite modified files")
-	fast         = flag.Bool("fast", false, "skip running goimports and simplify")
-	groupImports = flag.Bool("groupimports", true, "group imports by type")
-	printDiff    = flag.Bool("diff", true, "print diffs")
-	ignore       = flag.String("ignore", "", "regex matching files to skip")
-	localPrefix  = flag.String("local", "", "put imports beginning with this string after 3rd-party packages; comma-separated list")
-	srcDir       = flag.String("srcdir", "", "resolve imports as if the source file is from the given directory (if a file is given, the parent directory is used)")
-)
+	fs.IntVar(&s.wrapdoc, "wrapdoc", defaults.WrapDoc, "column at which to wrap doc strings for functions, variables, constants, and types. ignores multiline comments denoted by /*")
+	fs.IntVar(&s.wrap, "wrap", defaults.Wrap, "column to wrap at")
+	fs.IntVar(&s.tab, "tab", defaults.TabWidth, "tab width for column calculations")
+	fs.BoolVar(&s.overwrite, "w", false, "overwrite modified files")
+	fs.BoolVar(&s.fast, "fast", defaults.Fast, "skip running goimports and simplify")
+	fs.BoolVar(&s.groupImports, "groupimports", defaults.GroupImports, "group imports by type")
+	fs.BoolVar(&s.printDiff, "diff", true, "print diffs")
+	fs.StringVar(&s.color, "color", "auto", "highlight diffs: auto, always, or never; auto highlights them if standard output is a terminal and NO_COLOR is not set")
+	fs.IntVar(&s.diffContext, "diff-context", 3, "number of unchanged lines to show around each change in diffs")
+	fs.BoolVar(&s.diffWords, "diff-words", false, "when highlighting diffs, also highlight the words that changed within changed lines")
+	fs.StringVar(&s.ignore, "ignore", "", "regex matching files to skip")
+	fs.StringVar(&s.localPrefix, "local", "", "put imports beginning with this string after 3rd-party packages; comma-separated list")
+	fs.StringVar(&s.srcDir, "srcdir", "", "resolve imports as if the source file is from the given directory (if a file is given, the parent directory is used)")
+	fs.StringVar(&s.passes, "passes", "", "comma-separated list of the only passes to run; passes are "+passNames())
+	fs.StringVar(&s.skip, "skip", "", "comma-separated list of passes to skip")
+	fs.IntVar(&s.parallelism, "j", runtime.GOMAXPROCS(0), "number of files to format in parallel")
+	fs.StringVar(&s.cacheMode, "cache", "on", "skip files that are known to be formatted (on or off); 'crlfmt cache clean' empties the cache")
+	fs.BoolVar(&s.checkMode, "check", false, "do not overwrite files; exit with status 1 if any file is not formatted, and 2 on errors")
+	fs.BoolVar(&s.list, "l", false, "list files that are not formatted instead of printing diffs")
+	fs.StringVar(&s.since, "since", "", "only format the Go files that git reports as added or modified since the merge base of this revision and HEAD, along with untracked Go files that git does not ignore")
+	fs.Var(&s.lines, "lines", "only format the top-level declarations that overlap this range of lines, given as START:END; may be repeated")
+	fs.StringVar(&s.filesFrom, "files-from", "", "also format the files listed in this file, or in standard input if -, separated by newlines or NULs")
+	fs.StringVar(&s.generated, "generated", "skip", "what to do with generated files, which are marked with a '// Code generated ... DO NOT EDIT.' comment: skip them, format them, or check them without overwriting them")
+	fs.StringVar(&s.reportFormat, "format", "text", "how to report results: text prints diffs, or paths with -l; json prints a JSON object for each file on its own line; sarif prints a SARIF 2.1.0 log; github prints GitHub Actions annotations; gitlab prints a GitLab Code Quality report")
+	fs.StringVar(&s.patch, "patch", "", "also write the changes that formatting makes to files to this file, as a patch that git apply can apply")
+	fs.Var(&s.shard, "shard", "only format the files in shard I/N, where 0 <= I < N, of the files that would be formatted; files are assigned to shards by a hash of their paths")
+	fs.BoolVar(&s.verbose, "v", false, "report skipped files on standard error")
+	fs.BoolVar(&s.prune, "prune", true, "when walking directories, skip .git, .hg, .svn, node_modules, vendor, testdata, and bazel-* directories")
+	fs.BoolVar(&s.stopAtMods, "stop-at-modules", false, "when walking directories, skip the directories of nested modules, which contain a go.mod file")
+	fs.StringVar(&s.tags, "tags", "", "comma-separated list of build tags to satisfy when resolving package patterns such as ./...")
+	fs.BoolVar(&s.allVariants, "all-variants", false, "when resolving package patterns, include the files that build constraints exclude")
+	fs.StringVar(&s.stdinName, "stdin-filename", "", "when reading standard input, format it as the file at this path, which need not exist, for resolving imports and finding its configuration")
+	fs.BoolVar(&s.staged, "staged", false, "format the Go files that are added or modified in the git index, rather than in the working tree; -w updates the index")
+	fs.BoolVar(&s.gitDiffLines, "git-diff-lines", false, "only format the top-level declarations that overlap lines that git reports as added or modified since HEAD, or since the merge base given by -since; every line of an untracked file counts as added")
+	return s
+}
+
+// flags holds the values given on the command line.
+var flags = newSettings(flag.CommandLine)
+
+// errUnformatted is returned by run in check mode if any file is not
+// formatted.
+var errUnformatted = errors.New("files are not formatted")
+
+// errorStatus is the exit status used when run fails. It is changed by check
+// mode, so that errors can be told apart from unformatted files.
+var errorStatus = 1
 
 func main() {
-	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	if err := run(); errors.Is(err, errUnformatted) {
 		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(errorStatus)
 	}
 }
 
 func run() error {
 	flag.Parse()
 
-	if flag.NArg() == 0 {
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "cache":
+			return cacheCommand(flag.Args()[1:])
+		}
+	}
+
+	if err := flags.check(); err != nil {
+		return err
+	}
+	s, err := dirSettings(".")
+	if err != nil {
+		return err
+	}
+	if s.checkMode {
+		errorStatus = 2
+	}
+	verbose = s.verbose
+	if reporter, err = newReporter(s.reportFormat); err != nil {
+		return err
+	}
+
+	if flag.NArg() == 0 && s.since == "" && !s.gitDiffLines && !s.staged && s.filesFrom == "" {
 		content, err := io.ReadAll(os.Stdin)
 		if err != nil {
 			return err
 		}
 
-		*overwrite = true
-		*printDiff = false
-		out, err := checkBuf("<standard input>", content)
+		s.overwrite = true
+		s.printDiff = false
+		filename := "<standard input>"
+		fileSettings := s
+		if s.stdinName != "" {
+			filename = s.stdinName
+			if fileSettings, err = settingsFor(filename); err != nil {
+				return err
+			}
+			if fileSettings.ignores(filename) {
+				// Editors pipe files through crlfmt regardless of the
+				// configuration, so an ignored file is echoed unchanged.
+				verbosef("skipping ignored file %s", filename)
+				if s.reportFormat != "text" {
+					return reporter.Close(os.Stdout)
+				}
+				if !s.checkMode && !s.list {
+					_, err = os.Stdout.Write(content)
+					return err
+				}
+				return nil
+			}
+		}
+		if s.reportFormat != "text" {
+			// Report standard input like a file, rather than printing it.
+			out, err := checkSrc(os.Stdout, filename, content, fileSettings)
+			if err != nil {
+				return err
+			}
+			if err := reporter.Close(os.Stdout); err != nil {
+				return err
+			}
+			if s.checkMode && out != nil {
+				return errUnformatted
+			}
+			return nil
+		}
+		out := content
+		if !fileSettings.skips(filename, content) {
+			if out, _, err = formatSource(fileSettings, filename, content, false); err != nil {
+				return err
+			}
+		}
+		if !s.checkMode && !s.list {
+			if fileSettings.generated == "check" && format.IsGenerated(content) {
+				out = content
+			}
+			_, err = os.Stdout.Write(out)
+			return err
+		}
+		if bytes.Equal(content, out) {
+			return nil
+		}
+		if s.list {
+			fmt.Println(filename)
+		}
+		if s.checkMode {
+			return errUnformatted
+		}
+		return nil
+	}
+
+	roots := flag.Args()
+	if s.filesFrom != "" {
+		files, err := readFileList(s.filesFrom)
 		if err != nil {
 			return err
 		}
-		_, err = os.Stdout.Write(out)
+		roots = append(roots, files...)
+	}
+	if roots, err = resolvePatterns(roots, s); err != nil {
 		return err
 	}
+	if len(roots) == 0 && (flag.NArg() > 0 || s.filesFrom != "") {
+		// The files to format were given, but there are none.
+		return nil
+	}
 
-	var ignoreRE *regexp.Regexp
-	var err error
-	if len(*ignore) > 0 {
-		ignoreRE, err = regexp.Compile(*ignore)
-		if err != nil {
-			return fmt.Errorf("compiling ignore regexp: %s", err)
+	if err := openCache(s.cacheMode); err != nil {
+		return err
+	}
+	if s.patch != "" {
+		patchFile = newPatch()
+	}
+
+	check := checkPath
+	var staged []git.StagedFile
+	if s.staged {
+		if staged, err = git.StagedFiles(".", roots); err != nil {
+			return err
 		}
+		check = stagedChecker(staged)
 	}
 
+	p := newPool(s.parallelism, os.Stdout, check)
+	visit := s.shard.filter(p.submit)
+	var walkErr error
+	switch {
+	case s.staged:
+		walkErr = walkStaged(staged, s.gitDiffLines, roots, visit)
+	case s.since != "" || s.gitDiffLines:
+		walkErr = walkChanged(s.since, s.gitDiffLines, roots, visit)
+	default:
+		walkErr = walk(roots, s, visit)
+	}
+	if err := p.wait(); err != nil {
+		return fmt.Errorf("error during walk: %s", err)
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+	if patchFile != nil {
+		if err := patchFile.write(s.patch); err != nil {
+			return err
+		}
+	}
+	if err := reporter.Close(os.Stdout); err != nil {
+		return err
+	}
+	if s.checkMode && p.changed {
+		return errUnformatted
+	}
+	return nil
+}
+
+// walk calls visit for each Go file in the trees rooted at roots, along with
+// the file's settings, skipping files that the settings ignore. Files
+// reachable from more than one root are only visited once. Unless the run
+// settings s disable -prune, directories that hold no code to format, such as
+// .git and vendor, are skipped. With -stop-at-modules, so are directories that
+// contain a go.mod file. A root is always walked, even if it would be skipped.
+func walk(roots []string, s *settings, visit func(path string, s *settings) error) error {
 	visited := make(map[string]struct{})
 
-	for _, root := range flag.Args() {
+	for _, root := range roots {
 		resolved, err := filepath.EvalSymlinks(root)
 		if err != nil {
 			return fmt.Errorf("following symlinks in input path: %s", err)
 		}
 
-		err = filepath.Walk(resolved, func(path string, fi os.FileInfo, err error) error {
+		err = filepath.WalkDir(resolved, func(path string, d fs.DirEntry, err error) error {
 			if _, exists := visited[path]; exists {
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
 			}
 			visited[path] = struct{}{}
@@ -100,16 +330,21 @@ func run() error {
 			} else if err != nil {
 				return err
 			}
-			if ignoreRE != nil && ignoreRE.MatchString(path) {
-				return nil
+			if !d.IsDir() {
+				return visitFile(path, visit)
 			}
-			if fi.IsDir() {
+			if path == resolved {
 				return nil
 			}
-			if !strings.HasSuffix(path, ".go") {
-				return nil
+			if s.prune && pruned(d.Name()) {
+				return filepath.SkipDir
+			}
+			if s.stopAtMods {
+				if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+					return filepath.SkipDir
+				}
 			}
-			return checkPath(path)
+			return nil
 		})
 		if err != nil {
 			return fmt.Errorf("error during walk: %s", err)
@@ -119,258 +354,377 @@ func run() error {
 	return nil
 }
 
-func checkPath(path string) error {
-	src, err := os.ReadFile(path)
-	if err != nil {
-		return err
+// pruned reports whether walk skips directories with the given name. These
+// hold version control metadata, dependencies, test fixtures, or build
+// output.
+func pruned(name string) bool {
+	switch name {
+	case ".git", ".hg", ".svn", "node_modules", "vendor", "testdata":
+		return true
 	}
+	return strings.HasPrefix(name, "bazel-")
+}
 
-	output, err := checkBuf(path, src)
+// resolvePatterns returns roots with the package patterns among them, such as
+// ./... or import paths, replaced by the Go files of the matching packages.
+// Roots that exist in the file system are left as is.
+func resolvePatterns(roots []string, s *settings) ([]string, error) {
+	var paths, patterns []string
+	for _, root := range roots {
+		if isPattern(root) {
+			patterns = append(patterns, root)
+		} else {
+			paths = append(paths, root)
+		}
+	}
+	if len(patterns) == 0 {
+		return roots, nil
+	}
+	files, err := packages.Files(packages.Config{
+		Dir:         ".",
+		Tags:        splitList(s.tags),
+		AllVariants: s.allVariants,
+	}, patterns)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		// Report the files relative to the current directory, like the
+		// files found by walking.
+		if rel, err := filepath.Rel(wd, f); err == nil && !strings.HasPrefix(rel, "..") {
+			f = rel
+		}
+		paths = append(paths, f)
+	}
+	return paths, nil
+}
 
-	if !bytes.Equal(src, output) {
-		if *printDiff {
-			data, err := diff(src, output, path)
-			if err != nil {
-				return fmt.Errorf("computing diff: %s", err)
-			}
-			fmt.Printf("diff -u old/%[1]s new/%[1]s\n", filepath.ToSlash(path))
-			os.Stdout.Write(data)
+// isPattern reports whether root is a package pattern rather than a path in
+// the file system. Patterns contain "..." or are import paths, which do not
+// exist as paths. A missing .go file is reported as such rather than resolved
+// as a package.
+func isPattern(root string) bool {
+	if strings.Contains(root, "...") {
+		return true
+	}
+	if _, err := os.Lstat(root); err == nil || strings.HasSuffix(root, ".go") {
+		return false
+	}
+	return true
+}
+
+// readFileList returns the paths listed in the file at path, or in standard
+// input if path is "-". The paths are separated by newlines, or by NULs if the
+// list contains any.
+func readFileList(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading file list: %s", err)
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	var paths []string
+	for _, p := range strings.Split(string(data), sep) {
+		if sep == "\n" {
+			p = strings.TrimSuffix(p, "\r")
 		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
 
-		if *overwrite {
-			err := os.WriteFile(path, output, 0)
-			if err != nil {
+// walkChanged is like walk, but only visits the files within roots that were
+// added or modified since the merge base of rev and HEAD, or since HEAD if rev
+// is empty, or that are untracked. If there are no roots, files anywhere within the current directory
+// are visited. If lines is set, formatting of each file is restricted to the
+// lines that were added or modified.
+func walkChanged(
+	rev string, lines bool, roots []string, visit func(path string, s *settings) error,
+) error {
+	if !lines {
+		files, err := git.ChangedFiles(".", rev, roots)
+		if err != nil {
+			return err
+		}
+		for _, path := range files {
+			if err := visitFile(path, visit); err != nil {
 				return err
 			}
 		}
+		return nil
 	}
-	return nil
-}
 
-func checkBuf(path string, src []byte) ([]byte, error) {
-	output := new(bytes.Buffer)
-	if !*fast {
-		// Run goimports, which also runs gofmt.
-		importOpts := imports.Options{
-			AllErrors:  true,
-			Comments:   true,
-			TabIndent:  false,
-			TabWidth:   *tab,
-			FormatOnly: false,
+	files, err := git.ChangedLines(".", rev, roots)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := visitFile(f.Path, visitLines(f.Lines, visit)); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if localPrefix != nil && *localPrefix != "" {
-			imports.LocalPrefix = *localPrefix
+// visitLines returns a function that calls visit with settings that restrict
+// formatting to the given lines.
+func visitLines(
+	lines []git.Range, visit func(path string, s *settings) error,
+) func(path string, s *settings) error {
+	return func(path string, s *settings) error {
+		fileSettings := *s
+		fileSettings.opts.Lines = nil
+		for _, r := range lines {
+			fileSettings.opts.Lines = append(fileSettings.opts.Lines, format.LineRange{Start: r.Start, End: r.End})
 		}
+		return visit(path, &fileSettings)
+	}
+}
 
-		pathForImports := path
-		if *srcDir != "" {
-			filename := filepath.Base(path)
-			if isDirectory(*srcDir) {
-				pathForImports = filepath.Join(*srcDir, filename)
-			} else {
-				pathForImports = filepath.Join(filepath.Dir(*srcDir), filename)
-			}
-		}
+// visitFile calls visit for the file at path along with its settings, unless
+// it is not a Go file or its settings ignore it.
+func visitFile(path string, visit func(path string, s *settings) error) error {
+	if !strings.HasSuffix(path, ".go") {
+		return nil
+	}
+	s, err := settingsFor(path)
+	if err != nil {
+		return err
+	}
+	if s.ignores(path) {
+		verbosef("skipping ignored file %s", path)
+		return nil
+	}
+	return visit(path, s)
+}
 
-		newSrc, err := imports.Process(pathForImports, src, &importOpts)
+// checkPath formats the file at path with settings s and writes its diff, if
+// any, to w. It reports whether formatting changed the file.
+func checkPath(w io.Writer, path string, s *settings) (bool, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	output, err := checkSrc(w, path, src, s)
+	if err != nil || output == nil {
+		return false, err
+	}
+	if s.writes(src) {
+		err := os.WriteFile(path, output, 0)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		src = newSrc
+	}
+	return true, nil
+}
 
-		// Simplify
-		{
-			fileSet := token.NewFileSet()
-			f, err := goparser.ParseFile(fileSet, path, src, goparser.ParseComments)
-			if err != nil {
-				return nil, err
-			}
-			render.Simplify(f)
+// formatSource formats src, the contents of the file named filename, with
+// settings s. If hunks is set, it also returns the lines that formatting
+// changed, which are costly to find.
+func formatSource(
+	s *settings, filename string, src []byte, hunks bool,
+) ([]byte, []format.Hunk, error) {
+	if hunks {
+		return format.SourceHunks(filename, src, s.opts)
+	}
+	out, err := format.Source(filename, src, s.opts)
+	return out, nil, err
+}
 
-			prCfg := &printer.Config{
-				Tabwidth: *tab,
-				Mode:     printer.UseSpaces | printer.TabIndent,
-			}
-			var buf bytes.Buffer
-			prCfg.Fprint(&buf, fileSet, f)
-			src = buf.Bytes()
+// checkSrc formats src, the contents of the file at path, with settings s and
+// reports the result to w. It returns the formatted source if formatting
+// changed it, and nil otherwise.
+func checkSrc(w io.Writer, path string, src []byte, s *settings) ([]byte, error) {
+	if s.skips(path, src) {
+		return nil, nil
+	}
+	var err error
+	r := &fileResult{path: path, s: s, src: src}
+
+	var key cache.Key
+	if formatCache != nil {
+		if key, err = cacheKey(path, src, s.opts); err != nil {
+			return nil, err
+		}
+		if formatCache.Has(key) {
+			return nil, reporter.Report(w, r)
 		}
 	}
 
-	file, err := parser.ParseFile(path, src)
+	output, hunks, err := formatSource(s, path, src, reportsHunks())
 	if err != nil {
-		return nil, err
+		r.err = err
+		return nil, reporter.Report(w, r)
 	}
 
-	var importMapping map[*parser.ImportDecl][]render.ImportBlock
-	if *groupImports {
-		importMapping = remapImports(file)
-	}
-
-	lastPos := token.NoPos
-	for _, d := range file.Decls {
-		if imp, ok := d.(*parser.ImportDecl); ok && *groupImports {
-			blocks := importMapping[imp]
-			if blocks == nil {
-				// This import declaration is meant to be removed. If it's
-				// surrounded by blank lines, remove those too.
-				//
-				// If the import block is surrounded by blank lines, remove the
-				// blank lines too.
-				startPos, endPos := imp.Pos, imp.End
-				if off := file.Offset(startPos); off-2 >= 0 && src[off-1] == '\n' && src[off-2] == '\n' {
-					startPos = file.Pos(off - 1)
-				}
-				if off := file.Offset(endPos); off+1 < len(src) && src[off] == '\n' && src[off+1] == '\n' {
-					endPos = file.Pos(off + 1)
-				}
-				output.Write(file.Slice(lastPos, startPos))
-				lastPos = endPos
-				continue
-			}
-
-			var importBuf bytes.Buffer
-			if imp.Doc != nil && blocks[0].Size() > 1 {
-				importBuf.Write(file.Slice(imp.Doc.Pos(), imp.Doc.End()))
-				importBuf.WriteByte('\n')
-			}
-			for i, block := range blocks {
-				if i > 0 {
-					importBuf.WriteString("\n\n")
-				}
-				render.Imports(&importBuf, file, block)
-			}
-			newBytes, err := format.Source(importBuf.Bytes())
-			if err != nil {
-				return nil, fmt.Errorf("grouping imports for %s: %s", path, err)
+	if bytes.Equal(src, output) {
+		if formatCache != nil {
+			if err := formatCache.Put(key); err != nil {
+				return nil, err
 			}
-			output.Write(file.Slice(lastPos, imp.Pos))
-			output.Write(newBytes)
-			lastPos = imp.End
-		}
-		if fn, ok := d.(*parser.FuncDecl); ok {
-			var curFunc bytes.Buffer
-			render.Func(&curFunc, file, fn, *tab, *wrap, *wrapdoc, lastPos)
-			output.Write(curFunc.Bytes())
-			lastPos = fn.BodyEnd()
-		}
-		if cnst, ok := d.(*parser.ConstDecl); ok {
-			var declBuf bytes.Buffer
-			render.GenDecl(&declBuf, file, cnst.GenDecl, *wrapdoc, lastPos)
-			output.Write(declBuf.Bytes())
-			lastPos = cnst.End()
-		}
-		if vr, ok := d.(*parser.VarDecl); ok {
-			var declBuf bytes.Buffer
-			render.GenDecl(&declBuf, file, vr.GenDecl, *wrapdoc, lastPos)
-			output.Write(declBuf.Bytes())
-			lastPos = vr.End()
-		}
-		if typ, ok := d.(*parser.TypeDecl); ok {
-			var declBuf bytes.Buffer
-			render.GenDecl(&declBuf, file, typ.GenDecl, *wrapdoc, lastPos)
-			output.Write(declBuf.Bytes())
-			lastPos = typ.End()
 		}
+		return nil, reporter.Report(w, r)
 	}
 
-	output.Write(src[file.Offset(lastPos):])
-	return output.Bytes(), nil
+	r.out, r.hunks = output, hunks
+	if err := reporter.Report(w, r); err != nil {
+		return nil, err
+	}
+	if patchFile != nil {
+		patchFile.add(path, src, output)
+	}
+	return output, nil
 }
 
-// remapImports maps each existing import declaration in the file to an import
-// block that should replace it. An import block can contain multiple import
-// declarations, to indicate that the existing single import declaration should
-// be replaced with multiple separate import declarations, or nil, to indicate
-// that the import declaration should be removed entirely.
-//
-// The goal is to have just one import declaration, within which imports are
-// grouped standard library imports and non-standard library imports. An
-// exception is made for cgo, whose "C" psuedo-imports are extracted into
-// separate import declarations.
-func remapImports(file *parser.File) map[*parser.ImportDecl][]render.ImportBlock {
-	imports := file.ImportSpecs()
-	stdlibImports := make([]parser.ImportSpec, 0, len(imports))
-	otherImports := make([]parser.ImportSpec, 0, len(imports))
-	localImports := make([]parser.ImportSpec, 0, len(imports))
-
-	localPrefixes := []string{}
-	if localPrefix != nil && *localPrefix != "" {
-		lps := strings.Split(*localPrefix, ",")
-		localPrefixes = make([]string, 0, len(lps))
-		for _, lp := range lps {
-			if !strings.HasSuffix(lp, "/") {
-				lp += "/"
-			}
-			localPrefixes = append(localPrefixes, lp)
+// check validates the settings, and sets the fields derived from them.
+func (s *settings) check() error {
+	switch s.cacheMode {
+	case "on", "off":
+	default:
+		return fmt.Errorf("invalid -cache value %q: must be on or off", s.cacheMode)
+	}
+	if len(s.lines) > 0 && s.gitDiffLines {
+		return errors.New("-lines and -git-diff-lines cannot be used together")
+	}
+	switch s.color {
+	case "auto", "always", "never":
+	default:
+		return fmt.Errorf("invalid -color value %q: must be auto, always, or never", s.color)
+	}
+	if s.diffContext < 0 {
+		return fmt.Errorf("invalid -diff-context value %d: must not be negative", s.diffContext)
+	}
+	s.diffOpts = diff.UnifiedOptions{
+		Context: s.diffContext,
+		Color:   colorOutput(s.color),
+		Words:   s.diffWords,
+	}
+	switch s.generated {
+	case "skip", "format", "check":
+	default:
+		return fmt.Errorf("invalid -generated value %q: must be skip, format, or check", s.generated)
+	}
+	if s.staged && s.since != "" {
+		return errors.New("-staged and -since cannot be used together")
+	}
+	if len(s.ignore) > 0 {
+		var err error
+		s.ignoreRE, err = regexp.Compile(s.ignore)
+		if err != nil {
+			return fmt.Errorf("compiling ignore regexp: %s", err)
 		}
 	}
+	s.opts = format.Options{
+		Wrap:         s.wrap,
+		WrapDoc:      s.wrapdoc,
+		TabWidth:     s.tab,
+		Fast:         s.fast,
+		GroupImports: s.groupImports,
+		Passes:       splitList(s.passes),
+		Skip:         splitList(s.skip),
+		LocalPrefix:  s.localPrefix,
+		SrcDir:       s.srcDir,
+		Lines:        s.lines,
+	}
+	return s.opts.Validate()
+}
 
-NEXT_IMPORT:
-	for _, imp := range imports {
-		impPath := imp.Path()
-		if impPath == "C" {
-			continue NEXT_IMPORT
-		}
+// lineRanges is a flag.Value that collects ranges of lines given as
+// START:END. Several ranges can also be given at once, separated by commas.
+type lineRanges []format.LineRange
 
-		for _, lp := range localPrefixes {
-			if strings.HasPrefix(impPath, lp) {
-				localImports = append(localImports, imp)
-				continue NEXT_IMPORT
-			}
-		}
+func (r *lineRanges) String() string {
+	var ranges []string
+	for _, lr := range *r {
+		ranges = append(ranges, lr.String())
+	}
+	return strings.Join(ranges, ",")
+}
 
-		if strings.Contains(impPath, ".") {
-			otherImports = append(otherImports, imp)
-			continue NEXT_IMPORT
+func (r *lineRanges) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		start, end, ok := strings.Cut(v, ":")
+		lr := format.LineRange{}
+		var err1, err2 error
+		lr.Start, err1 = strconv.Atoi(start)
+		lr.End, err2 = strconv.Atoi(end)
+		if !ok || err1 != nil || err2 != nil {
+			return fmt.Errorf("invalid line range %q: must be START:END", v)
 		}
+		*r = append(*r, lr)
+	}
+	return nil
+}
+
+// ignores reports whether the file at path is skipped, either because an
+// override ignores it or because it matches the -ignore regex, which acts as
+// an override that applies to every configuration.
+func (s *settings) ignores(path string) bool {
+	return s.ignored || (s.ignoreRE != nil && s.ignoreRE.MatchString(path))
+}
 
-		stdlibImports = append(stdlibImports, imp)
+// skips reports whether the file at path with contents src is left alone
+// because it is generated and -generated=skip, which is the default.
+func (s *settings) skips(path string, src []byte) bool {
+	if s.generated == "skip" && format.IsGenerated(src) {
+		verbosef("skipping generated file %s", path)
+		return true
 	}
+	return false
+}
 
-	mainBlock := render.ImportBlock{stdlibImports, otherImports, localImports}
-	needMainBlock := mainBlock.Size() > 0
+// writes reports whether a file with contents src is overwritten when it is
+// not formatted. Generated files are not with -generated=check.
+func (s *settings) writes(src []byte) bool {
+	return s.overwrite && !s.checkMode && !(s.generated == "check" && format.IsGenerated(src))
+}
 
-	mapping := map[*parser.ImportDecl][]render.ImportBlock{}
-	impDecls := file.ImportDecls()
-	for _, imp := range impDecls {
-		var blocks []render.ImportBlock
-		var cImports []parser.ImportSpec
-		for _, spec := range imp.Specs {
-			if spec.Path() == "C" {
-				cImports = append(cImports, spec)
-			}
-		}
-		if needMainBlock && len(cImports) != len(imp.Specs) {
-			// The first import declaration we see that contains something other
-			// than "C" psuedo-imports will be our main import block.
-			blocks = append(blocks, mainBlock)
-			needMainBlock = false
-		}
-		// If there were any "C" psuedo-imports in this declaration, split them
-		// out into their own import declarations.
-		for _, imp := range cImports {
-			if imp.Doc == nil {
-				// A cgo import without a doc comment has no effect. Remove it.
-				continue
-			}
-			blocks = append(blocks, render.ImportBlock{{imp}})
-		}
-		mapping[imp] = blocks
+// verbose is the value of -v for the run.
+var verbose bool
+
+var verboseMu sync.Mutex
+
+// verbosef reports a message on standard error if -v is set.
+func verbosef(msg string, args ...interface{}) {
+	if !verbose {
+		return
 	}
-	return mapping
+	verboseMu.Lock()
+	defer verboseMu.Unlock()
+	fmt.Fprintf(os.Stderr, "crlfmt: "+msg+"\n", args...)
 }
 
-// isDirectory returns true if the path is a directory. False is
-// returned on any error.
-func isDirectory(path string) bool {
-	fileInfo, err := os.Stat(path)
-	if err != nil {
-		return false
+// passNames returns the names of all formatting passes in pipeline order,
+// separated by commas.
+func passNames() string {
+	var names []string
+	for _, p := range format.Passes() {
+		names = append(names, p.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// splitList splits a comma-separated list, dropping empty elements.
+func splitList(s string) []string {
+	var out []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
 	}
-	return fileInfo.IsDir()
+	return out
 }
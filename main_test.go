@@ -15,8 +15,10 @@
 package main
 
 import (
-	"flag"
+	"bytes"
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -24,40 +26,308 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-var rewrite = flag.Bool("rewrite", false, "used to rewrite output")
-
-func TestCheckPath(t *testing.T) {
-	defer func(old bool) { *printDiff = old }(*printDiff)
-	*printDiff = false
-	*tab = 8
-	*groupImports = false
-	*wrapdoc = 80
-	files, err := filepath.Glob("testdata/*.in.go")
-	if err != nil {
-		t.Fatal(err)
-	}
-	for _, file := range files {
-		t.Run(filepath.Base(file), func(t *testing.T) {
-			inBytes, err := os.ReadFile(file)
-			if err != nil {
-				t.Fatal(err)
-			}
-			outFile := strings.Replace(file, ".in.go", ".out.go", -1)
-
-			output, err := checkBuf(file, inBytes)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if *rewrite {
-				err := os.WriteFile(outFile, output, 0666)
-				require.NoError(t, err)
-			} else {
-				expBytes, err := os.ReadFile(outFile)
-				if err != nil {
-					t.Fatal(err)
-				}
-				require.Equal(t, string(expBytes), string(output))
-			}
+// TestMain runs crlfmt instead of the tests if CRLFMT_TEST_MAIN is set, so
+// that the tests can run the test binary as crlfmt.
+func TestMain(m *testing.M) {
+	if os.Getenv("CRLFMT_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// A cmdResult is the result of running crlfmt.
+type cmdResult struct {
+	stdout, stderr string
+	status         int
+}
+
+// crlfmt runs crlfmt with args in dir, with stdin as its standard input. Each
+// run has its own cache.
+func crlfmt(t *testing.T, dir, stdin string, args ...string) cmdResult {
+	t.Helper()
+	exe, err := os.Executable()
+	require.NoError(t, err)
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "CRLFMT_TEST_MAIN=1", "XDG_CACHE_HOME="+t.TempDir())
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	var r cmdResult
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		require.True(t, errors.As(err, &exitErr), "running crlfmt: %s", err)
+		r.status = exitErr.ExitCode()
+	}
+	r.stdout, r.stderr = stdout.String(), stderr.String()
+	return r
+}
+
+// writeFiles writes files, given by their slash-separated paths relative to
+// dir, and their contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0666))
+	}
+}
+
+// readFile returns the contents of the file at the slash-separated path name
+// relative to dir.
+func readFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	require.NoError(t, err)
+	return string(b)
+}
+
+// runGit runs git with args in dir.
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, "git %s: %s", strings.Join(args, " "), out)
+}
+
+// Sources used by the tests. Each is formatted the same way with -fast.
+const (
+	formatted   = "package a\n\nfunc f() {}\n"
+	unformatted = "package a\n\nfunc f( ) {}\n"
+	invalid     = "package a\n\nfunc f( {}\n"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"ok/a.go":  formatted,
+		"bad/a.go": formatted,
+		"bad/b.go": unformatted,
+		"bad/c.go": unformatted,
+		"err/a.go": invalid,
+	})
+
+	r := crlfmt(t, dir, "", "-fast", "-check", "ok")
+	require.Equal(t, cmdResult{}, r)
+
+	// Unformatted files are reported, but not overwritten even with -w.
+	r = crlfmt(t, dir, "", "-fast", "-check", "-w", "bad")
+	require.Equal(t, 1, r.status)
+	require.Contains(t, r.stdout, "diff -u old/bad/b.go new/bad/b.go\n")
+	require.Contains(t, r.stdout, "-func f( ) {}\n+func f() {}\n")
+	require.Contains(t, r.stdout, "diff -u old/bad/c.go new/bad/c.go\n")
+	require.Empty(t, r.stderr)
+	require.Equal(t, unformatted, readFile(t, dir, "bad/b.go"))
+
+	// Errors are told apart from unformatted files.
+	r = crlfmt(t, dir, "", "-fast", "-check", ".")
+	require.Equal(t, 2, r.status)
+	require.Contains(t, r.stderr, "err/a.go:3:9")
+	r = crlfmt(t, dir, "", "-fast", "err")
+	require.Equal(t, 1, r.status)
+
+	r = crlfmt(t, dir, unformatted, "-fast", "-check")
+	require.Equal(t, cmdResult{status: 1}, r)
+	r = crlfmt(t, dir, formatted, "-fast", "-check")
+	require.Equal(t, cmdResult{}, r)
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go":     unformatted,
+		"b.go":     formatted,
+		"sub/c.go": unformatted,
+	})
+
+	r := crlfmt(t, dir, "", "-fast", "-l", ".")
+	require.Equal(t, cmdResult{stdout: "a.go\n" + filepath.Join("sub", "c.go") + "\n"}, r)
+
+	r = crlfmt(t, dir, "", "-fast", "-l", "-check", ".")
+	require.Equal(t, cmdResult{stdout: "a.go\n" + filepath.Join("sub", "c.go") + "\n", status: 1}, r)
+
+	// -l -w lists the files that it overwrites.
+	r = crlfmt(t, dir, "", "-fast", "-l", "-w", ".")
+	require.Equal(t, cmdResult{stdout: "a.go\n" + filepath.Join("sub", "c.go") + "\n"}, r)
+	require.Equal(t, formatted, readFile(t, dir, "a.go"))
+	require.Equal(t, formatted, readFile(t, dir, "sub/c.go"))
+
+	r = crlfmt(t, dir, "", "-fast", "-l", "-check", ".")
+	require.Equal(t, cmdResult{}, r)
+
+	r = crlfmt(t, dir, unformatted, "-fast", "-l", "-stdin-filename=x.go")
+	require.Equal(t, cmdResult{stdout: "x.go\n"}, r)
+}
+
+func TestReadFileList(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name, list string
+		want       []string
+	}{
+		{name: "newlines", list: "a.go\nb c.go\r\n\nd.go", want: []string{"a.go", "b c.go", "d.go"}},
+		{name: "nuls", list: "a.go\x00b\nc.go\x00\x00d.go\x00", want: []string{"a.go", "b\nc.go", "d.go"}},
+		{name: "empty", list: ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			require.NoError(t, os.WriteFile(path, []byte(tc.list), 0666))
+			paths, err := readFileList(path)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, paths)
 		})
 	}
+	_, err := readFileList(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestFilesFrom(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go":       unformatted,
+		"b\nc.go":    unformatted,
+		"d.go":       unformatted,
+		"sub/e.go":   unformatted,
+		"not-go.txt": unformatted,
+		"list":       "a.go\x00b\nc.go\x00./a.go\x00sub\x00not-go.txt\x00",
+	})
+
+	// The files listed follow the arguments. Each file is formatted once, even
+	// if it is listed more than once or is also given as an argument.
+	r := crlfmt(t, dir, "", "-fast", "-l", "-files-from=list", "a.go", "sub/e.go")
+	require.Equal(t, cmdResult{stdout: "a.go\n" + filepath.Join("sub", "e.go") + "\nb\nc.go\n"}, r)
+
+	r = crlfmt(t, dir, "a.go\nd.go\na.go\n", "-fast", "-l", "-files-from=-")
+	require.Equal(t, cmdResult{stdout: "a.go\nd.go\n"}, r)
+
+	// An empty list formats nothing, rather than the current directory.
+	r = crlfmt(t, dir, "", "-fast", "-l", "-files-from=-")
+	require.Equal(t, cmdResult{}, r)
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go":              unformatted,
+		".git/a.go":         unformatted,
+		"bazel-out/a.go":    unformatted,
+		"nested/go.mod":     "module nested\n",
+		"nested/a.go":       unformatted,
+		"node_modules/a.go": unformatted,
+		"sub/a.go":          unformatted,
+		"sub/vendor/a.go":   unformatted,
+		"testdata/a.go":     unformatted,
+		"vendor/a.go":       unformatted,
+	})
+	list := func(paths ...string) cmdResult {
+		var out strings.Builder
+		for _, p := range paths {
+			out.WriteString(filepath.FromSlash(p) + "\n")
+		}
+		return cmdResult{stdout: out.String()}
+	}
+
+	r := crlfmt(t, dir, "", "-fast", "-l", ".")
+	require.Equal(t, list("a.go", "nested/a.go", "sub/a.go"), r)
+
+	r = crlfmt(t, dir, "", "-fast", "-l", "-prune=false", ".")
+	require.Equal(t, list(
+		".git/a.go", "a.go", "bazel-out/a.go", "nested/a.go", "node_modules/a.go",
+		"sub/a.go", "sub/vendor/a.go", "testdata/a.go", "vendor/a.go",
+	), r)
+
+	r = crlfmt(t, dir, "", "-fast", "-l", "-stop-at-modules", ".")
+	require.Equal(t, list("a.go", "sub/a.go"), r)
+
+	// A root is walked even if it would be skipped.
+	r = crlfmt(t, dir, "", "-fast", "-l", "-stop-at-modules", "vendor", "nested")
+	require.Equal(t, list("vendor/a.go", "nested/a.go"), r)
+}
+
+func TestGenerated(t *testing.T) {
+	const generated = "// Code generated by test. DO NOT EDIT.\n\n" + unformatted
+	const generatedOut = "// Code generated by test. DO NOT EDIT.\n\n" + formatted
+	for _, tc := range []struct {
+		mode string
+		// list is the output of -l.
+		list string
+		// out is the generated file after -w.
+		out string
+		// stdin is the output for the generated file on standard input.
+		stdin string
+	}{
+		{mode: "skip", list: "a.go\n", out: generated, stdin: generated},
+		{mode: "format", list: "a.go\ngen.go\n", out: generatedOut, stdin: generatedOut},
+		{mode: "check", list: "a.go\ngen.go\n", out: generated, stdin: generated},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"a.go": unformatted, "gen.go": generated})
+			mode := "-generated=" + tc.mode
+
+			r := crlfmt(t, dir, "", "-fast", mode, "-l", "-check", ".")
+			require.Equal(t, cmdResult{stdout: tc.list, status: 1}, r)
+
+			r = crlfmt(t, dir, "", "-fast", mode, "-l", "-w", ".")
+			require.Equal(t, cmdResult{stdout: tc.list}, r)
+			require.Equal(t, formatted, readFile(t, dir, "a.go"))
+			require.Equal(t, tc.out, readFile(t, dir, "gen.go"))
+
+			r = crlfmt(t, dir, generated, "-fast", mode)
+			require.Equal(t, cmdResult{stdout: tc.stdin}, r)
+		})
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"gen.go": generated})
+	r := crlfmt(t, dir, "", "-fast", "-v", ".")
+	require.Equal(t, cmdResult{stderr: "crlfmt: skipping generated file gen.go\n"}, r)
+}
+
+func TestStdinIgnored(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".crlfmt.yaml": "overrides:\n  - files: pkg/a\n    ignore: true\n",
+	})
+
+	// A file that a directory walk would skip is echoed unchanged.
+	for _, args := range [][]string{
+		{"-stdin-filename=pkg/a/a.go"},
+		{"-stdin-filename=b.go", `-ignore=b\.go`},
+	} {
+		r := crlfmt(t, dir, unformatted, append([]string{"-fast"}, args...)...)
+		require.Equal(t, cmdResult{stdout: unformatted}, r, args)
+		r = crlfmt(t, dir, unformatted, append([]string{"-fast", "-check"}, args...)...)
+		require.Equal(t, cmdResult{}, r, args)
+	}
+
+	r := crlfmt(t, dir, unformatted, "-fast", "-stdin-filename=pkg/b/b.go")
+	require.Equal(t, cmdResult{stdout: formatted}, r)
+}
+
+func TestStagedLines(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	writeFiles(t, dir, map[string]string{
+		"a.go": unformatted,
+		"b.go": unformatted,
+	})
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "-c", "user.name=crlfmt", "-c", "user.email=crlfmt@example.com", "commit", "-q", "-m", "init")
+
+	// A file whose mode alone changed has no changed lines.
+	require.NoError(t, os.Chmod(filepath.Join(dir, "a.go"), 0777))
+	writeFiles(t, dir, map[string]string{"b.go": unformatted + "\nfunc g( ) {}\n"})
+	runGit(t, dir, "add", ".")
+
+	r := crlfmt(t, dir, "", "-fast", "-l", "-staged", "-git-diff-lines")
+	require.Equal(t, cmdResult{stdout: "b.go\n"}, r)
+	r = crlfmt(t, dir, "", "-fast", "-l", "-staged")
+	require.Equal(t, cmdResult{stdout: "a.go\nb.go\n"}, r)
 }
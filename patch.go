@@ -0,0 +1,130 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/crlfmt/internal/diff"
+	"github.com/cockroachdb/crlfmt/internal/git"
+)
+
+// patchFile collects the diffs of the run for -patch, if it is set.
+var patchFile *patch
+
+// A patch collects the changes that formatting makes to files into one patch
+// in the format of git diff, which git apply can apply.
+type patch struct {
+	// prefix is the path of the current directory relative to the root of
+	// its repository, since git apply expects paths relative to the root.
+	prefix string
+
+	mu    sync.Mutex
+	diffs map[string][]byte // by path in the patch
+}
+
+// newPatch returns an empty patch for files in the current directory.
+func newPatch() *patch {
+	return &patch{prefix: repoPrefix(), diffs: make(map[string][]byte)}
+}
+
+// repoPrefix returns the path of the current directory relative to the root
+// of its repository, with a trailing slash. Outside of a repository, it
+// returns "", so that paths stay relative to the current directory.
+func repoPrefix() string {
+	prefix, err := git.Prefix(".")
+	if err != nil {
+		return ""
+	}
+	return prefix
+}
+
+// repoPath returns the slash-separated path of the file at filename relative
+// to the root of its repository, where prefix is the result of repoPrefix.
+func repoPath(prefix, filename string) string {
+	if filepath.IsAbs(filename) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, filename); err == nil {
+				filename = rel
+			}
+		}
+	}
+	return path.Clean(prefix + filepath.ToSlash(filename))
+}
+
+// add adds the changes that formatting makes to src, the contents of the file
+// at filename, to produce out.
+func (p *patch) add(filename string, src, out []byte) {
+	name := repoPath(p.prefix, filename)
+	a, b := quotePath("a/"+name), quotePath("b/"+name)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "diff --git %s %s\n", a, b)
+	buf.Write(diff.Unified(a, b, src, out, diff.UnifiedOptions{Context: 3}))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.diffs[name] = buf.Bytes()
+}
+
+// write writes the patch to the file at filename, with the files sorted by
+// path. If no file changed, the file is empty.
+func (p *patch) write(filename string) error {
+	names := make([]string, 0, len(p.diffs))
+	for name := range p.diffs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.Write(p.diffs[name])
+	}
+	return os.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// quotePath quotes a path in a patch the way git does, if it contains
+// characters that git quotes.
+func quotePath(name string) string {
+	if !strings.ContainsAny(name, "\"\\") && strings.IndexFunc(name, func(r rune) bool {
+		return r < 0x20 || r >= 0x7f
+	}) < 0 {
+		return name
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
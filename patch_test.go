@@ -0,0 +1,80 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	files := map[string]string{
+		"a.go":          unformatted,
+		"b.go":          formatted,
+		"sub/c.go":      unformatted,
+		"sub/d é f.go":  unformatted,
+		"sub/no-eol.go": strings.TrimSuffix(unformatted, "\n"),
+	}
+	writeFiles(t, dir, files)
+	sub := filepath.Join(dir, "sub")
+
+	// The patch is written from a subdirectory, with paths relative to the
+	// root of the repository, and does not change the files.
+	r := crlfmt(t, sub, "", "-fast", "-l", "-patch=../p.patch", ".", filepath.Join("..", "a.go"), filepath.Join(dir, "b.go"))
+	require.Equal(t, 0, r.status, r.stderr)
+	for name, content := range files {
+		require.Equal(t, content, readFile(t, dir, name))
+	}
+	patch := readFile(t, dir, "p.patch")
+	require.True(t, strings.HasPrefix(patch, "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n"), patch)
+	require.Contains(t, patch, "diff --git a/sub/c.go b/sub/c.go\n")
+	require.Contains(t, patch, `diff --git "a/sub/d \303\251 f.go" "b/sub/d \303\251 f.go"`+"\n")
+	require.Contains(t, patch, "\\ No newline at end of file\n")
+	require.NotContains(t, patch, "b.go b/b.go")
+
+	runGit(t, dir, "apply", "--check", "p.patch")
+	runGit(t, dir, "apply", "p.patch")
+	r = crlfmt(t, dir, "", "-fast", "-l", "-check", "a.go", "b.go", "sub")
+	require.Equal(t, cmdResult{}, r)
+
+	// Without changes, the patch is empty.
+	r = crlfmt(t, dir, "", "-fast", "-patch=p.patch", "a.go", "sub")
+	require.Equal(t, cmdResult{}, r)
+	require.Equal(t, "", readFile(t, dir, "p.patch"))
+}
+
+func TestQuotePath(t *testing.T) {
+	for path, want := range map[string]string{
+		"a/b.go":      "a/b.go",
+		"a/b c.go":    "a/b c.go",
+		"a/é.go":      `"a/\303\251.go"`,
+		"a/\"b\".go":  `"a/\"b\".go"`,
+		"a/b\\c.go":   `"a/b\\c.go"`,
+		"a/b\tc.go":   `"a/b\tc.go"`,
+		"a/b\nc.go":   `"a/b\nc.go"`,
+		"a/b\x01c.go": `"a/b\001c.go"`,
+	} {
+		require.Equal(t, want, quotePath(path), path)
+	}
+}
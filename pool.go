@@ -0,0 +1,129 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// errStopped is returned by pool.submit once a file has failed to format.
+var errStopped = errors.New("stopped after error")
+
+// A job is a file submitted to a pool.
+type job struct {
+	path string
+	s    *settings
+	out  bytes.Buffer
+	// changed is set if formatting changed the file.
+	changed bool
+	err     error
+	done    chan struct{}
+}
+
+// A pool formats files on a bounded number of goroutines. The output of each
+// file is written in the order in which the files were submitted, so that it
+// does not depend on the number of goroutines. The pool stops at the first
+// file, in submission order, that fails to format.
+type pool struct {
+	w     io.Writer
+	check func(w io.Writer, path string, s *settings) (bool, error)
+
+	work  chan *job // jobs waiting for a worker
+	order chan *job // jobs waiting to be written, in submission order
+
+	workers sync.WaitGroup
+	written chan struct{} // closed when all jobs have been written
+	stopped chan struct{} // closed when a job fails
+	err     error         // the error of the first failed job
+	changed bool          // whether formatting changed any written job's file
+}
+
+// newPool starts a pool that formats files with check on n goroutines and
+// writes their output to w. The check function is called like checkPath.
+func newPool(
+	n int, w io.Writer, check func(w io.Writer, path string, s *settings) (bool, error),
+) *pool {
+	if n < 1 {
+		n = 1
+	}
+	p := &pool{
+		w:       w,
+		check:   check,
+		work:    make(chan *job),
+		order:   make(chan *job, 2*n),
+		written: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	p.workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer p.workers.Done()
+			for j := range p.work {
+				j.changed, j.err = p.check(&j.out, j.path, j.s)
+				close(j.done)
+			}
+		}()
+	}
+	go p.write()
+	return p
+}
+
+// write writes the output of each job once it is done, in submission order.
+func (p *pool) write() {
+	defer close(p.written)
+	for j := range p.order {
+		<-j.done
+		if p.err != nil {
+			continue
+		}
+		if j.err != nil {
+			p.err = j.err
+			close(p.stopped)
+			continue
+		}
+		if _, err := p.w.Write(j.out.Bytes()); err != nil {
+			p.err = err
+			close(p.stopped)
+		}
+		p.changed = p.changed || j.changed
+	}
+}
+
+// submit queues the file at path to be formatted with settings s. It returns
+// errStopped if a previously submitted file failed to format.
+func (p *pool) submit(path string, s *settings) error {
+	j := &job{path: path, s: s, done: make(chan struct{})}
+	select {
+	case p.order <- j:
+	case <-p.stopped:
+		return errStopped
+	}
+	p.work <- j
+	return nil
+}
+
+// wait waits for all submitted files to be formatted and written, and returns
+// the error of the first file that failed, if any. Once wait returns,
+// p.changed reports whether formatting changed any of the files.
+func (p *pool) wait() error {
+	close(p.work)
+	p.workers.Wait()
+	close(p.order)
+	<-p.written
+	return p.err
+}
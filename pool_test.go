@@ -0,0 +1,73 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolOrder(t *testing.T) {
+	// Earlier files take longer, so that they finish last.
+	const n = 20
+	var out bytes.Buffer
+	p := newPool(4, &out, func(w io.Writer, path string, s *settings) (bool, error) {
+		var i int
+		fmt.Sscanf(path, "%d.go", &i)
+		time.Sleep(time.Duration(n-i) * time.Millisecond)
+		fmt.Fprintln(w, path)
+		return i == 7, nil
+	})
+	var want strings.Builder
+	for i := 0; i < n; i++ {
+		path := fmt.Sprintf("%d.go", i)
+		require.NoError(t, p.submit(path, flags))
+		fmt.Fprintln(&want, path)
+	}
+	require.NoError(t, p.wait())
+	require.Equal(t, want.String(), out.String())
+	require.True(t, p.changed)
+}
+
+func TestPoolError(t *testing.T) {
+	errBad := errors.New("bad")
+	var out bytes.Buffer
+	p := newPool(4, &out, func(w io.Writer, path string, s *settings) (bool, error) {
+		if path == "2.go" {
+			// The files after this one may finish first, but are not
+			// written.
+			time.Sleep(10 * time.Millisecond)
+			return false, errBad
+		}
+		fmt.Fprintln(w, path)
+		return path == "3.go", nil
+	})
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		err = p.submit(fmt.Sprintf("%d.go", i), flags)
+	}
+	// Submitting stops once the error has been seen.
+	require.True(t, errors.Is(err, errStopped))
+	require.True(t, errors.Is(p.wait(), errBad))
+	require.Equal(t, "0.go\n1.go\n", out.String())
+	require.False(t, p.changed)
+}
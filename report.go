@@ -0,0 +1,467 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/cockroachdb/crlfmt/internal/diff"
+	"github.com/cockroachdb/crlfmt/internal/sarif"
+)
+
+// A fileResult is the result of checking a file.
+type fileResult struct {
+	path string
+	s    *settings
+	src  []byte
+	// out is the formatted source if formatting changed the file, and nil
+	// otherwise.
+	out   []byte
+	hunks []format.Hunk
+	// err is set if the file could not be formatted.
+	err error
+}
+
+// A Reporter reports the result of checking each file. The result of each
+// file is written to the output of that file, which is written in the order in
+// which files were submitted, regardless of -j. Report is called concurrently
+// for different files.
+type Reporter interface {
+	// Report reports the result of checking one file to w. An error returned
+	// by Report stops the run.
+	Report(w io.Writer, r *fileResult) error
+	// Close writes anything that follows the results of the files to w. It
+	// returns an error if the run failed because of a reported error.
+	Close(w io.Writer) error
+}
+
+// reporter is the Reporter selected by -format.
+var reporter Reporter = textReporter{}
+
+// reportFormats lists the values of -format.
+var reportFormats = []string{"text", "json", "sarif", "github", "gitlab"}
+
+// newReporter returns the Reporter for the given -format value.
+func newReporter(format string) (Reporter, error) {
+	switch format {
+	case "text":
+		return textReporter{}, nil
+	case "json":
+		return &jsonReporter{}, nil
+	case "sarif":
+		return &sarifReporter{}, nil
+	case "github":
+		return &githubReporter{prefix: repoPrefix()}, nil
+	case "gitlab":
+		return &gitlabReporter{prefix: repoPrefix()}, nil
+	}
+	return nil, fmt.Errorf("invalid -format value %q: must be one of %s", format, strings.Join(reportFormats, ", "))
+}
+
+// reportsHunks reports whether the reporter reports the lines that formatting
+// changes in each file. Only the text reporter does not, which spares it the
+// cost of finding them.
+func reportsHunks() bool {
+	_, text := reporter.(textReporter)
+	return !text
+}
+
+// textReporter reports files that formatting changes with a unified diff, or
+// with their paths if -l is set. It stops the run at the first file that
+// cannot be formatted.
+type textReporter struct{}
+
+func (textReporter) Report(w io.Writer, r *fileResult) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.out == nil {
+		return nil
+	}
+	if r.s.list {
+		fmt.Fprintln(w, r.path)
+	} else if r.s.printDiff {
+		f := filepath.ToSlash(r.path)
+		fmt.Fprintf(w, "diff -u old/%[1]s new/%[1]s\n", f)
+		w.Write(diff.Unified("old/"+f, "new/"+f, r.src, r.out, r.s.diffOpts))
+	}
+	return nil
+}
+
+func (textReporter) Close(w io.Writer) error {
+	return nil
+}
+
+// jsonReporter reports each file as a JSON object on its own line. Files that
+// cannot be formatted are reported along with their errors, and fail the run
+// once every file has been reported.
+type jsonReporter struct {
+	failed int32
+}
+
+// A jsonFile is the JSON record of a file.
+type jsonFile struct {
+	Path    string        `json:"path"`
+	Changed bool          `json:"changed"`
+	Errors  []jsonError   `json:"errors,omitempty"`
+	Hunks   []format.Hunk `json:"hunks,omitempty"`
+}
+
+// A jsonError is an error that prevented a file from being formatted. Line and
+// Column are zero if the error has no position.
+type jsonError struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (j *jsonReporter) Report(w io.Writer, r *fileResult) error {
+	rec := jsonFile{Path: filepath.ToSlash(r.path), Changed: r.out != nil, Hunks: r.hunks}
+	if r.err != nil {
+		atomic.AddInt32(&j.failed, 1)
+		rec.Errors = errorPositions(r.err)
+	}
+	return json.NewEncoder(w).Encode(&rec)
+}
+
+func (j *jsonReporter) Close(w io.Writer) error {
+	return failedFiles(int(atomic.LoadInt32(&j.failed)))
+}
+
+// sarifReporter reports the changes that formatting makes to files as a SARIF
+// log, with a result for each hunk. Since the log is a single JSON document,
+// it is written once every file has been reported, with the files sorted by
+// path. Errors are reported as notifications, and fail the run.
+type sarifReporter struct {
+	mu     sync.Mutex
+	files  []sarif.File
+	failed int
+}
+
+func (r *sarifReporter) Report(w io.Writer, res *fileResult) error {
+	if res.out == nil && res.err == nil {
+		return nil
+	}
+	f := sarif.File{Path: filepath.ToSlash(res.path), Src: res.src, Out: res.out}
+	src := diff.Lines(res.src)
+	for _, h := range res.hunks {
+		c := sarif.Change{Message: hunkMessage(h, src, res.s), Hunk: h}
+		if len(h.Passes) > 0 {
+			c.RuleID = h.Passes[0]
+		}
+		f.Changes = append(f.Changes, c)
+	}
+	if res.err != nil {
+		for _, e := range errorPositions(res.err) {
+			f.Errors = append(f.Errors, sarif.Error{Line: e.Line, Column: e.Column, Message: e.Message})
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, f)
+	if res.err != nil {
+		r.failed++
+	}
+	return nil
+}
+
+func (r *sarifReporter) Close(w io.Writer) error {
+	sort.Slice(r.files, func(i, j int) bool { return r.files[i].Path < r.files[j].Path })
+	var rules []sarif.Rule
+	for _, p := range format.Passes() {
+		rules = append(rules, sarif.Rule{ID: p.Name(), Description: p.Doc()})
+	}
+	if err := sarif.Write(w, rules, r.files); err != nil {
+		return err
+	}
+	return failedFiles(r.failed)
+}
+
+// githubReporter reports each hunk that formatting would change as a warning
+// annotation, with the workflow commands of GitHub Actions. Files that cannot
+// be formatted are reported as error annotations, and fail the run once every
+// file has been reported.
+type githubReporter struct {
+	failed int32
+	// prefix is the path of the current directory relative to the root of
+	// its repository, since annotations are located relative to the root.
+	prefix string
+}
+
+var (
+	githubData     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	githubProperty = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func (r *githubReporter) Report(w io.Writer, res *fileResult) error {
+	file := githubProperty.Replace(repoPath(r.prefix, res.path))
+	if res.err != nil {
+		atomic.AddInt32(&r.failed, 1)
+		for _, e := range errorPositions(res.err) {
+			pos := ""
+			if e.Line > 0 {
+				pos = fmt.Sprintf(",line=%d", e.Line)
+			}
+			if e.Column > 0 {
+				pos += fmt.Sprintf(",col=%d", e.Column)
+			}
+			fmt.Fprintf(w, "::error file=%s%s,title=crlfmt::%s\n", file, pos, githubData.Replace(e.Message))
+		}
+		return nil
+	}
+	src := diff.Lines(res.src)
+	for _, h := range res.hunks {
+		start, end := h.OldRange(len(src))
+		fmt.Fprintf(w, "::warning file=%s,line=%d,endLine=%d,title=crlfmt::%s\n",
+			file, start, end, githubData.Replace(hunkMessage(h, src, res.s)))
+	}
+	return nil
+}
+
+func (r *githubReporter) Close(w io.Writer) error {
+	return failedFiles(int(atomic.LoadInt32(&r.failed)))
+}
+
+// gitlabReporter reports each hunk that formatting would change as an issue in
+// a GitLab Code Quality report. Since the report is a single JSON document, it
+// is written once every file has been reported, with the issues sorted by
+// location. Files that cannot be formatted are reported as major issues, and
+// fail the run.
+type gitlabReporter struct {
+	// prefix is the path of the current directory relative to the root of
+	// its repository, since issues are located relative to the root.
+	prefix string
+
+	mu     sync.Mutex
+	issues []gitlabIssue
+	failed int
+}
+
+// A gitlabIssue is an issue in a GitLab Code Quality report.
+type gitlabIssue struct {
+	Description string         `json:"description"`
+	CheckName   string         `json:"check_name"`
+	Fingerprint string         `json:"fingerprint"`
+	Severity    string         `json:"severity"`
+	Location    gitlabLocation `json:"location"`
+}
+
+type gitlabLocation struct {
+	Path  string      `json:"path"`
+	Lines gitlabLines `json:"lines"`
+}
+
+type gitlabLines struct {
+	Begin int `json:"begin"`
+	End   int `json:"end,omitempty"`
+}
+
+func (r *gitlabReporter) Report(w io.Writer, res *fileResult) error {
+	if res.out == nil && res.err == nil {
+		return nil
+	}
+	path := repoPath(r.prefix, res.path)
+	var issues []gitlabIssue
+	if res.err != nil {
+		for _, e := range errorPositions(res.err) {
+			line := e.Line
+			if line < 1 {
+				line = 1
+			}
+			issues = append(issues, gitlabIssue{
+				Description: e.Message,
+				CheckName:   "error",
+				Fingerprint: fingerprint(path, "error", e.Message),
+				Severity:    "major",
+				Location:    gitlabLocation{Path: path, Lines: gitlabLines{Begin: line}},
+			})
+		}
+	}
+	src, out := diff.Lines(res.src), diff.Lines(res.out)
+	for _, h := range res.hunks {
+		check := "format"
+		if len(h.Passes) > 0 {
+			check = h.Passes[0]
+		}
+		start, end := h.OldRange(len(src))
+		// The fingerprint of an issue does not depend on its lines, so that
+		// it stays the same when lines are added above it.
+		old := bytes.Join(src[h.OldStart-1:h.OldStart-1+h.OldLines], nil)
+		text := bytes.Join(out[h.NewStart-1:h.NewStart-1+h.NewLines], nil)
+		issues = append(issues, gitlabIssue{
+			Description: hunkMessage(h, src, res.s),
+			CheckName:   check,
+			Fingerprint: fingerprint(path, check, string(old), string(text)),
+			Severity:    "minor",
+			Location:    gitlabLocation{Path: path, Lines: gitlabLines{Begin: start, End: end}},
+		})
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.issues = append(r.issues, issues...)
+	if res.err != nil {
+		r.failed++
+	}
+	return nil
+}
+
+func (r *gitlabReporter) Close(w io.Writer) error {
+	sort.SliceStable(r.issues, func(i, j int) bool {
+		a, b := r.issues[i].Location, r.issues[j].Location
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Lines.Begin < b.Lines.Begin
+	})
+	// Identical issues in a file need distinct fingerprints.
+	seen := make(map[string]int)
+	for i := range r.issues {
+		fp := r.issues[i].Fingerprint
+		if n := seen[fp]; n > 0 {
+			r.issues[i].Fingerprint = fingerprint(fp, strconv.Itoa(n))
+		}
+		seen[fp]++
+	}
+	issues := r.issues
+	if issues == nil {
+		issues = []gitlabIssue{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(issues); err != nil {
+		return err
+	}
+	return failedFiles(r.failed)
+}
+
+// fingerprint returns a hash of parts.
+func fingerprint(parts ...string) string {
+	h := md5.New()
+	for _, p := range parts {
+		io.WriteString(h, p)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hunkMessage describes the changes that the passes of hunk h make to the
+// lines src with settings s.
+func hunkMessage(h format.Hunk, src [][]byte, s *settings) string {
+	old := src[h.OldStart-1 : h.OldStart-1+h.OldLines]
+	var msgs []string
+	for _, p := range h.Passes {
+		switch p {
+		case "goimports":
+			msgs = append(msgs, "imports or formatting would be fixed by goimports")
+		case "simplify":
+			msgs = append(msgs, "code would be simplified")
+		case "imports":
+			msgs = append(msgs, "imports would be grouped by type")
+		case "wrap":
+			if exceeds(old, s.wrap, s.tab) {
+				msgs = append(msgs, fmt.Sprintf("signature exceeds %d columns, would be rewrapped", s.wrap))
+			} else {
+				msgs = append(msgs, "signature would be reformatted")
+			}
+		case "wrapdoc":
+			if exceeds(old, s.wrapdoc, s.tab) {
+				msgs = append(msgs, fmt.Sprintf("doc comment exceeds %d columns, would be rewrapped", s.wrapdoc))
+			} else {
+				msgs = append(msgs, "doc comment would be reformatted")
+			}
+		default:
+			msgs = append(msgs, fmt.Sprintf("would be changed by %s", p))
+		}
+	}
+	if len(msgs) == 0 {
+		return "would be reformatted"
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// exceeds reports whether any of lines is wider than n columns, counting each
+// tab as tab columns like the wrap passes do.
+func exceeds(lines [][]byte, n, tab int) bool {
+	for _, l := range lines {
+		width := 0
+		for _, r := range string(bytes.TrimRight(l, "\r\n")) {
+			if r == '\t' {
+				width += tab
+			} else {
+				width++
+			}
+		}
+		if width > n {
+			return true
+		}
+	}
+	return false
+}
+
+// failedFiles returns an error that reports that n files could not be
+// formatted, or nil if n is zero.
+func failedFiles(n int) error {
+	switch n {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 file could not be formatted")
+	default:
+		return fmt.Errorf("%d files could not be formatted", n)
+	}
+}
+
+// positionRE matches the position in the message of an error that was
+// formatted as a string rather than returned as a scanner.Error.
+var positionRE = regexp.MustCompile(`:(\d+):(\d+): `)
+
+// errorPositions splits err into the errors it holds, along with their
+// positions.
+func errorPositions(err error) []jsonError {
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		out := make([]jsonError, len(list))
+		for i, e := range list {
+			out[i] = jsonError{Line: e.Pos.Line, Column: e.Pos.Column, Message: e.Msg}
+		}
+		return out
+	}
+	var e *scanner.Error
+	if errors.As(err, &e) {
+		return []jsonError{{Line: e.Pos.Line, Column: e.Pos.Column, Message: e.Msg}}
+	}
+	msg := err.Error()
+	if m := positionRE.FindStringSubmatchIndex(msg); m != nil {
+		line, _ := strconv.Atoi(msg[m[2]:m[3]])
+		col, _ := strconv.Atoi(msg[m[4]:m[5]])
+		return []jsonError{{Line: line, Column: col, Message: msg[m[1]:]}}
+	}
+	return []jsonError{{Message: msg}}
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/crlfmt/format"
+	"github.com/stretchr/testify/require"
+)
+
+// longFunc is a source with a signature and a doc comment that exceed 60
+// columns, which are changed in one hunk by two passes.
+const longFunc = `package a
+
+// G is documented by a comment that is longer than sixty columns.
+func G(aaaaaaaaaa int, bbbbbbbbbbbb int, ccccccccccc int, ddddddddddd int) {}
+`
+
+func TestReportJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go": unformatted,
+		"b.go": formatted,
+		"c.go": invalid,
+		"d.go": longFunc,
+	})
+
+	// A file that cannot be formatted is reported, and fails the run once
+	// every file has been reported.
+	r := crlfmt(t, dir, "", "-fast", "-wrap=60", "-wrapdoc=60", "-format=json", ".")
+	require.Equal(t, 1, r.status)
+	require.Equal(t, "error: 1 file could not be formatted\n", r.stderr)
+	var files []jsonFile
+	dec := json.NewDecoder(strings.NewReader(r.stdout))
+	for dec.More() {
+		var f jsonFile
+		require.NoError(t, dec.Decode(&f))
+		files = append(files, f)
+	}
+	require.Len(t, files, 4)
+	require.Equal(t, jsonFile{
+		Path:    "a.go",
+		Changed: true,
+		Hunks:   []format.Hunk{{OldStart: 3, OldLines: 1, NewStart: 3, NewLines: 1, Passes: []string{"wrap"}}},
+	}, files[0])
+	require.Equal(t, jsonFile{Path: "b.go"}, files[1])
+	require.Equal(t, "c.go", files[2].Path)
+	require.False(t, files[2].Changed)
+	require.NotEmpty(t, files[2].Errors)
+	require.Equal(t, jsonError{Line: 3, Column: 9, Message: "expected ')', found '{'"}, files[2].Errors[0])
+	require.Equal(t, jsonFile{
+		Path:    "d.go",
+		Changed: true,
+		Hunks:   []format.Hunk{{OldStart: 3, OldLines: 2, NewStart: 3, NewLines: 8, Passes: []string{"wrap", "wrapdoc"}}},
+	}, files[3])
+
+	// Errors exit with status 2 in check mode, like the text output.
+	r = crlfmt(t, dir, "", "-fast", "-check", "-format=json", "a.go", "b.go")
+	require.Equal(t, 1, r.status)
+	r = crlfmt(t, dir, "", "-fast", "-check", "-format=json", "c.go")
+	require.Equal(t, 2, r.status)
+
+	// Standard input is reported like a file, rather than printed.
+	r = crlfmt(t, dir, unformatted, "-fast", "-format=json", "-stdin-filename=x.go")
+	require.Equal(t, cmdResult{
+		stdout: `{"path":"x.go","changed":true,"hunks":[{"old_start":3,"old_lines":1,"new_start":3,"new_lines":1,"passes":["wrap"]}]}` + "\n",
+	}, r)
+}
+
+func TestReportGitHub(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a,b:c.go": unformatted,
+		"b.go":     formatted,
+		"c.go":     invalid,
+		"d.go":     longFunc,
+	})
+
+	r := crlfmt(t, dir, "", "-fast", "-wrap=60", "-wrapdoc=60", "-format=github", ".")
+	require.Equal(t, 1, r.status)
+	require.Equal(t, "error: 1 file could not be formatted\n", r.stderr)
+	lines := strings.Split(strings.TrimSuffix(r.stdout, "\n"), "\n")
+	require.Greater(t, len(lines), 3)
+	// Properties are escaped.
+	require.Equal(t, "::warning file=a%2Cb%3Ac.go,line=3,endLine=3,title=crlfmt::signature would be reformatted", lines[0])
+	require.Equal(t, "::error file=c.go,line=3,col=9,title=crlfmt::expected ')', found '{'", lines[1])
+	for _, l := range lines[2 : len(lines)-1] {
+		require.True(t, strings.HasPrefix(l, "::error file=c.go,line=3,"), l)
+	}
+	require.Equal(t, "::warning file=d.go,line=3,endLine=4,title=crlfmt::signature exceeds 60 columns, would be rewrapped; doc comment exceeds 60 columns, would be rewrapped", lines[len(lines)-1])
+
+	require.Equal(t, "100%25%0Adone%0D", githubData.Replace("100%\ndone\r"))
+}
+
+func TestReportGitLab(t *testing.T) {
+	// The same change twice, which gets two fingerprints.
+	const twice = "package a\n\nfunc f( ) {}\n\nfunc f( ) {}\n"
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.go": unformatted,
+		"b.go": formatted,
+		"c.go": invalid,
+		"d.go": longFunc,
+		"e.go": twice,
+	})
+	report := func() []gitlabIssue {
+		r := crlfmt(t, dir, "", "-fast", "-wrap=60", "-wrapdoc=60", "-format=gitlab", ".")
+		require.Equal(t, 1, r.status)
+		require.Equal(t, "error: 1 file could not be formatted\n", r.stderr)
+		var issues []gitlabIssue
+		require.NoError(t, json.Unmarshal([]byte(r.stdout), &issues))
+		return issues
+	}
+
+	issues := report()
+	require.Greater(t, len(issues), 5)
+	n := len(issues)
+	require.Equal(t, gitlabIssue{
+		Description: "signature would be reformatted",
+		CheckName:   "wrap",
+		Fingerprint: issues[0].Fingerprint,
+		Severity:    "minor",
+		Location:    gitlabLocation{Path: "a.go", Lines: gitlabLines{Begin: 3, End: 3}},
+	}, issues[0])
+	require.Equal(t, gitlabIssue{
+		Description: "expected ')', found '{'",
+		CheckName:   "error",
+		Fingerprint: issues[1].Fingerprint,
+		Severity:    "major",
+		Location:    gitlabLocation{Path: "c.go", Lines: gitlabLines{Begin: 3}},
+	}, issues[1])
+	require.Equal(t, gitlabLocation{Path: "d.go", Lines: gitlabLines{Begin: 3, End: 4}}, issues[n-3].Location)
+	require.Equal(t, "wrap", issues[n-3].CheckName)
+	require.Equal(t, gitlabLocation{Path: "e.go", Lines: gitlabLines{Begin: 3, End: 3}}, issues[n-2].Location)
+	require.Equal(t, gitlabLocation{Path: "e.go", Lines: gitlabLines{Begin: 5, End: 5}}, issues[n-1].Location)
+
+	// Every issue has its own fingerprint, even identical ones.
+	fingerprints := make(map[string]bool)
+	for _, issue := range issues {
+		require.False(t, fingerprints[issue.Fingerprint], "duplicate fingerprint %s", issue.Fingerprint)
+		fingerprints[issue.Fingerprint] = true
+	}
+
+	// Fingerprints do not change when lines are added above the issues.
+	writeFiles(t, dir, map[string]string{"d.go": strings.Replace(longFunc, "\n\n", "\n\nvar x int\n\n", 1)})
+	moved := report()
+	require.Len(t, moved, n)
+	require.Equal(t, gitlabLines{Begin: 5, End: 6}, moved[n-3].Location.Lines)
+	require.Equal(t, issues[n-3].Fingerprint, moved[n-3].Fingerprint)
+
+	r := crlfmt(t, dir, "", "-fast", "-format=gitlab", "b.go")
+	require.Equal(t, cmdResult{stdout: "[]\n"}, r)
+}
+
+func TestReportRepoPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	runGit(t, dir, "init", "-q")
+	writeFiles(t, dir, map[string]string{"sub/a.go": unformatted})
+	sub := filepath.Join(dir, "sub")
+
+	// Annotations are located relative to the root of the repository, even
+	// when crlfmt runs in a subdirectory.
+	r := crlfmt(t, sub, "", "-fast", "-format=github", ".")
+	require.Equal(t, cmdResult{
+		stdout: "::warning file=sub/a.go,line=3,endLine=3,title=crlfmt::signature would be reformatted\n",
+	}, r)
+
+	r = crlfmt(t, sub, "", "-fast", "-format=gitlab", filepath.Join(sub, "a.go"))
+	require.Equal(t, 0, r.status, r.stderr)
+	var issues []gitlabIssue
+	require.NoError(t, json.Unmarshal([]byte(r.stdout), &issues))
+	require.Len(t, issues, 1)
+	require.Equal(t, "sub/a.go", issues[0].Location.Path)
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// shard is a flag.Value that selects one of n disjoint subsets of the files
+// to format, given as I/N with 0 <= I < N. The zero value selects every file.
+type shard struct {
+	i, n int
+}
+
+func (sh *shard) String() string {
+	if sh.n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", sh.i, sh.n)
+}
+
+func (sh *shard) Set(value string) error {
+	i, n, ok := strings.Cut(value, "/")
+	var err1, err2 error
+	sh.i, err1 = strconv.Atoi(i)
+	sh.n, err2 = strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || sh.n <= 0 || sh.i < 0 || sh.i >= sh.n {
+		*sh = shard{}
+		return fmt.Errorf("invalid shard %q: must be I/N with 0 <= I < N", value)
+	}
+	return nil
+}
+
+// includes reports whether the file at path belongs to the shard. The path is
+// hashed in slash-separated form relative to the current directory, so that
+// every machine that runs crlfmt from the root of the same tree assigns the
+// same files to the same shard.
+func (sh *shard) includes(path string) bool {
+	if sh.n == 0 {
+		return true
+	}
+	if filepath.IsAbs(path) {
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, path); err == nil {
+				path = rel
+			}
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(filepath.ToSlash(filepath.Clean(path))))
+	return int(h.Sum32()%uint32(sh.n)) == sh.i
+}
+
+// filter returns a visit function that calls visit for the files that belong
+// to the shard.
+func (sh *shard) filter(
+	visit func(path string, s *settings) error,
+) func(path string, s *settings) error {
+	return func(path string, s *settings) error {
+		if !sh.includes(path) {
+			return nil
+		}
+		return visit(path, s)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardSet(t *testing.T) {
+	for _, v := range []string{"0/1", "0/4", "3/4"} {
+		var sh shard
+		require.NoError(t, sh.Set(v))
+		require.Equal(t, v, sh.String())
+	}
+	for _, v := range []string{"", "1", "4/4", "-1/4", "0/0", "a/4", "0/b", "1/2/3"} {
+		var sh shard
+		require.Error(t, sh.Set(v), v)
+		require.Equal(t, "", sh.String())
+	}
+}
+
+func TestShard(t *testing.T) {
+	// The paths of files under an absolute root have its symlinks resolved.
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		files[fmt.Sprintf("d%d/f%d.go", i%3, i)] = unformatted
+	}
+	writeFiles(t, dir, files)
+	lines := func(s string) []string {
+		return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	}
+
+	r := crlfmt(t, dir, "", "-fast", "-l", ".")
+	require.Equal(t, 0, r.status, r.stderr)
+	all := lines(r.stdout)
+	require.Len(t, all, len(files))
+	sort.Strings(all)
+
+	for _, n := range []int{1, 2, 3, 7} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			// Every file belongs to exactly one shard.
+			var union []string
+			for i := 0; i < n; i++ {
+				sh := fmt.Sprintf("-shard=%d/%d", i, n)
+				r := crlfmt(t, dir, "", "-fast", "-l", sh, ".")
+				require.Equal(t, 0, r.status, r.stderr)
+				if r.stdout == "" {
+					continue
+				}
+				shard := lines(r.stdout)
+				union = append(union, shard...)
+
+				// Files are assigned by their paths relative to the current
+				// directory, however they are given.
+				r = crlfmt(t, dir, "", "-fast", "-l", sh, dir)
+				require.Equal(t, 0, r.status, r.stderr)
+				var rel []string
+				for _, p := range lines(r.stdout) {
+					p, err := filepath.Rel(dir, p)
+					require.NoError(t, err)
+					rel = append(rel, p)
+				}
+				require.Equal(t, shard, rel)
+			}
+			sort.Strings(union)
+			require.Equal(t, all, union)
+		})
+	}
+
+	r = crlfmt(t, dir, "", "-fast", "-shard=3/3", ".")
+	require.Equal(t, 2, r.status)
+	require.Contains(t, r.stderr, `invalid shard "3/3"`)
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cockroachdb/crlfmt/internal/git"
+)
+
+// walkStaged is like walk, but visits the staged files. If lines is set,
+// formatting of each file is restricted to the lines that differ between the
+// index and HEAD.
+func walkStaged(
+	staged []git.StagedFile, lines bool, roots []string, visit func(path string, s *settings) error,
+) error {
+	var changed map[string][]git.Range
+	if lines {
+		files, err := git.StagedLines(".", roots)
+		if err != nil {
+			return err
+		}
+		changed = make(map[string][]git.Range)
+		for _, f := range files {
+			changed[f.Path] = f.Lines
+		}
+	}
+	for _, f := range staged {
+		v := visit
+		if lines {
+			// Files without changed lines, such as those whose mode alone
+			// changed, have nothing to format.
+			ranges, ok := changed[f.Path]
+			if !ok {
+				continue
+			}
+			v = visitLines(ranges, visit)
+		}
+		if err := visitFile(f.Path, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagedChecker returns a function like checkPath that formats the staged
+// contents of the given files instead of the contents in the working tree.
+// With -w, it writes the result to the index. The working tree copy of a file
+// is only updated as well if it has no unstaged changes, so that unstaged
+// changes are never lost.
+func stagedChecker(
+	staged []git.StagedFile,
+) func(w io.Writer, path string, s *settings) (bool, error) {
+	byPath := make(map[string]git.StagedFile)
+	for _, f := range staged {
+		byPath[f.Path] = f
+	}
+	return func(w io.Writer, path string, s *settings) (bool, error) {
+		f, ok := byPath[path]
+		if !ok {
+			return false, fmt.Errorf("%s is not staged", path)
+		}
+		src, err := git.ReadBlob(".", f.Blob)
+		if err != nil {
+			return false, err
+		}
+		output, err := checkSrc(w, path, src, s)
+		if err != nil || output == nil {
+			return false, err
+		}
+		if s.writes(src) {
+			if err := git.WriteStaged(".", f, output); err != nil {
+				return false, err
+			}
+			if cur, err := os.ReadFile(path); err == nil && bytes.Equal(cur, src) {
+				if err := os.WriteFile(path, output, 0); err != nil {
+					return false, err
+				}
+			}
+		}
+		return true, nil
+	}
+}